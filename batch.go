@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go-reloaded/processor"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// stringList is a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// job is one file to process in batch mode. With inPlace set, output is
+// the input itself and backup the suffix of its backup copy, if any.
+type job struct {
+	input   string
+	output  string
+	inPlace bool
+	backup  string
+}
+
+// jobResult is the outcome of a job.
+type jobResult struct {
+	job
+	diags []processor.Diagnostic
+	err   error
+}
+
+// collectJobs expands the directories given with -r and the positional
+// arguments (files, globs or directories) into jobs. Files found under a
+// directory keep their path relative to it inside outDir; single files are
+// written to outDir under their base name. An empty outDir means every
+// file is rewritten in place. A file given more than once is processed
+// once.
+func collectJobs(dirs, args []string, outDir string) ([]job, error) {
+	var jobs []job
+	inputs := map[string]bool{}
+	seen := map[string]string{}
+
+	add := func(input, rel string) error {
+		absInput, err := filepath.Abs(input)
+		if err != nil {
+			return err
+		}
+		// A file named twice, say with -r and on its own, is processed
+		// once; two in-place jobs would race on the same file and backup.
+		if inputs[absInput] {
+			return nil
+		}
+		inputs[absInput] = true
+
+		if outDir == "" {
+			jobs = append(jobs, job{input: input, output: input, inPlace: true})
+			return nil
+		}
+
+		output := filepath.Join(outDir, rel)
+		absOutput, err := filepath.Abs(output)
+		if err != nil {
+			return err
+		}
+		// Creating the output would truncate the input before it is read.
+		if absOutput == absInput || sameFile(input, output) {
+			return fmt.Errorf("%s would be overwritten by its own output (use -i to edit in place)", input)
+		}
+		if other, ok := seen[absOutput]; ok {
+			return fmt.Errorf("%s and %s would both be written to %s", other, input, output)
+		}
+		seen[absOutput] = input
+		jobs = append(jobs, job{input: input, output: output})
+		return nil
+	}
+
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match '%s'", arg)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir() {
+				dirs = append(dirs, match)
+				continue
+			}
+			if err := add(match, filepath.Base(match)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	absOut := ""
+	if outDir != "" {
+		absOut, _ = filepath.Abs(outDir)
+	}
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				// Don't read back what we are writing
+				if abs, _ := filepath.Abs(path); abs == absOut {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !isValidTxtFile(path) {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			return add(path, rel)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(jobs) == 0 {
+		return nil, errors.New("no input files")
+	}
+	return jobs, nil
+}
+
+// sameFile reports whether a and b name the same existing file, even
+// through a link.
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// inputFiles lists the files named by -r directories and positional
+// arguments for the modes that only read them. Without any, it returns
+// stdio so that stdin is read.
+func inputFiles(dirs, args []string) ([]string, error) {
+	if len(dirs) == 0 && (len(args) == 0 || (len(args) == 1 && args[0] == stdio)) {
+		return []string{stdio}, nil
+	}
+
+	jobs, err := collectJobs(dirs, args, "")
+	if err != nil {
+		return nil, err
+	}
+	inputs := make([]string, len(jobs))
+	for i, j := range jobs {
+		inputs[i] = j.input
+	}
+	return inputs, nil
+}
+
+// runBatch processes jobs with a pool of workers, then prints the
+// diagnostics and a summary line for every file in input order; with quiet
+// set, only failures are summarized. It reports whether all files
+// succeeded.
+func runBatch(proc *processor.Processor, jobs []job, workers int, quiet bool) bool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]jobResult, len(jobs))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = runJob(proc, jobs[i])
+			}
+		}()
+	}
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	failed := 0
+	for _, r := range results {
+		for _, d := range r.diags {
+			printDiagnostic(r.input, d)
+		}
+
+		var diagErr *processor.DiagnosticsError
+		switch {
+		case errors.As(r.err, &diagErr):
+			failed++
+			fmt.Printf("FAIL %s: %s\n", r.input, plural(diagErr.Count, "error"))
+		case r.err != nil:
+			failed++
+			fmt.Printf("FAIL %s: %v\n", r.input, r.err)
+		case quiet:
+		case len(r.diags) > 0:
+			fmt.Printf("ok   %s -> %s (%s)\n", r.input, r.output, plural(len(r.diags), "warning"))
+		default:
+			fmt.Printf("ok   %s -> %s\n", r.input, r.output)
+		}
+	}
+
+	if !quiet || failed > 0 {
+		fmt.Printf("%d files processed, %d failed\n", len(results), failed)
+	}
+	return failed == 0
+}
+
+func runJob(proc *processor.Processor, j job) jobResult {
+	result := jobResult{job: j}
+	report := func(d processor.Diagnostic) {
+		result.diags = append(result.diags, d)
+	}
+
+	if j.inPlace {
+		result.err = rewriteFile(proc, j.input, j.backup, report)
+		return result
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.output), 0755); err != nil {
+		result.err = err
+		return result
+	}
+	result.err = processFile(proc, j.input, j.output, report)
+	return result
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollectJobs(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "docs/a.txt", "")
+	b := writeFile(t, dir, "docs/sub/b.txt", "")
+	writeFile(t, dir, "docs/notes.md", "")
+	out := filepath.Join(dir, "out")
+
+	jobs, err := collectJobs([]string{filepath.Join(dir, "docs")}, nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		a: filepath.Join(out, "a.txt"),
+		b: filepath.Join(out, "sub", "b.txt"),
+	}
+	if len(jobs) != len(want) {
+		t.Fatalf("got %d jobs, want %d: %v", len(jobs), len(want), jobs)
+	}
+	for _, j := range jobs {
+		if want[j.input] != j.output || j.inPlace {
+			t.Errorf("%s -> %s, want %s", j.input, j.output, want[j.input])
+		}
+	}
+}
+
+func TestCollectJobsSkipsDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	docs := filepath.Join(dir, "docs")
+	a := writeFile(t, docs, "a.txt", "")
+	writeFile(t, docs, "b.txt", "")
+
+	for _, outDir := range []string{"", filepath.Join(dir, "out")} {
+		jobs, err := collectJobs([]string{docs, docs + "/"}, []string{a, filepath.Join(docs, ".", "a.txt")}, outDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(jobs) != 2 {
+			t.Errorf("output dir %q: got %d jobs, want 2: %v", outDir, len(jobs), jobs)
+		}
+	}
+}
+
+func TestCollectJobsRejectsCollisions(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "one/a.txt", "")
+	other := writeFile(t, dir, "two/a.txt", "")
+
+	if _, err := collectJobs(nil, []string{a, other}, filepath.Join(dir, "out")); err == nil || !strings.Contains(err.Error(), "both be written") {
+		t.Errorf("got %v, want a collision error", err)
+	}
+}
+
+func TestCollectJobsRejectsOwnOutput(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.txt", "hello (up)\n")
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, outDir := range []string{dir, link} {
+		if _, err := collectJobs(nil, []string{a}, outDir); err == nil || !strings.Contains(err.Error(), "its own output") {
+			t.Errorf("output dir %s: got %v, want an error", outDir, err)
+		}
+	}
+	if got, _ := os.ReadFile(a); string(got) != "hello (up)\n" {
+		t.Errorf("the input was changed to %q", got)
+	}
+}
@@ -0,0 +1,273 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op is what happens to a line going from the old text to the new one.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Line is one line of a line diff. Text keeps its trailing newline, if any.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// SplitLines splits text into lines, keeping the newline at the end of each.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// maxCost bounds the number of edits searched for in each half of a split.
+// Past it the split falls on the furthest-reaching path found so far, as
+// GNU diff does: the script is still correct, but no longer always the
+// shortest. This keeps two large, unrelated files from taking quadratic
+// time.
+const maxCost = 4096
+
+// Lines returns a shortest edit script turning a into b, computed with the
+// linear-space variant of Myers' O(ND) algorithm.
+func Lines(a, b []string) []Line {
+	script := make([]Line, 0, max(len(a), len(b)))
+	return compare(script, a, b)
+}
+
+// compare appends the edit script turning a into b to script. It splits
+// the problem at the middle snake of a shortest path and recurses on both
+// halves, so only the two frontiers are ever kept in memory.
+func compare(script []Line, a, b []string) []Line {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for _, line := range a[:prefix] {
+		script = append(script, Line{Equal, line})
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(midA) > 0 && len(midB) > 0 {
+		x, y, u, v := middleSnake(midA, midB)
+		script = compare(script, midA[:x], midB[:y])
+		for _, line := range midA[x:u] {
+			script = append(script, Line{Equal, line})
+		}
+		script = compare(script, midA[u:], midB[v:])
+		midA, midB = nil, nil
+	}
+	for _, line := range midA {
+		script = append(script, Line{Delete, line})
+	}
+	for _, line := range midB {
+		script = append(script, Line{Insert, line})
+	}
+	for _, line := range a[len(a)-suffix:] {
+		script = append(script, Line{Equal, line})
+	}
+	return script
+}
+
+// middleSnake runs Myers' search from both ends of a and b at once until
+// the two paths meet, and returns the diagonal run where they do: a[x:u]
+// equals b[y:v]. Both a and b must be non-empty and differ in their first
+// and last lines, which makes both halves around the snake smaller than
+// the whole. When the paths have not met within maxCost edits, the split
+// is an empty snake at the end of whichever path got furthest.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	limit := min((n+m+1)/2, maxCost)
+	offset := limit + 1
+
+	// forward[offset+k] is the furthest x reached on diagonal k = x-y
+	// from the start; backward[offset+k] the same from the end, counting
+	// both coordinates backwards.
+	forward := make([]int, 2*limit+3)
+	backward := make([]int, 2*limit+3)
+
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x0 int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x0 = forward[offset+k+1]
+			} else {
+				x0 = forward[offset+k-1] + 1
+			}
+			y0 := x0 - k
+			x, y := x0, y0
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			if c := delta - k; odd && c >= -(d-1) && c <= d-1 && x+backward[offset+c] >= n {
+				return x0, y0, x, y
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x0 int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x0 = backward[offset+k+1]
+			} else {
+				x0 = backward[offset+k-1] + 1
+			}
+			y0 := x0 - k
+			x, y := x0, y0
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if c := delta - k; !odd && c >= -d && c <= d && x+forward[offset+c] >= n {
+				return n - x, m - y, n - x0, m - y0
+			}
+		}
+	}
+
+	// Every diagonal of the same parity as limit was reached in its last
+	// round. Points off the edit graph are skipped; the first and last
+	// lines differ, so the best point is neither corner.
+	best, bestX, bestY := -1, 0, 0
+	for k := -limit; k <= limit; k += 2 {
+		if x, y := forward[offset+k], forward[offset+k]-k; x <= n && y >= 0 && y <= m && x+y > best {
+			best, bestX, bestY = x+y, x, y
+		}
+		if x, y := backward[offset+k], backward[offset+k]-k; x <= n && y >= 0 && y <= m && x+y > best {
+			best, bestX, bestY = x+y, n-x, m-y
+		}
+	}
+	return bestX, bestY, bestX, bestY
+}
+
+// Options controls the output of Unified.
+type Options struct {
+	FromName string
+	ToName   string
+
+	// Context is the number of unchanged lines shown around each change.
+	Context int
+
+	// Color highlights the output with ANSI escape codes.
+	Color bool
+}
+
+const (
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+	colorReset = "\x1b[0m"
+)
+
+// Unified returns the unified diff between texts a and b, or "" when they
+// are equal.
+func Unified(a, b string, opts Options) string {
+	if a == b {
+		return ""
+	}
+
+	script := Lines(SplitLines(a), SplitLines(b))
+
+	// aPos[i] and bPos[i] count the old and new lines before script[i].
+	aPos := make([]int, len(script)+1)
+	bPos := make([]int, len(script)+1)
+	for i, line := range script {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if line.Op != Insert {
+			aPos[i+1]++
+		}
+		if line.Op != Delete {
+			bPos[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	paint := func(color, s string) {
+		if opts.Color {
+			line := strings.TrimSuffix(s, "\n")
+			out.WriteString(color + line + colorReset + s[len(line):])
+		} else {
+			out.WriteString(s)
+		}
+	}
+
+	paint(colorBold, "--- "+opts.FromName+"\n")
+	paint(colorBold, "+++ "+opts.ToName+"\n")
+
+	for start := 0; start < len(script); {
+		// Find the next change and extend the hunk over every change that
+		// is close enough to share context with it.
+		first := start
+		for first < len(script) && script[first].Op == Equal {
+			first++
+		}
+		if first == len(script) {
+			break
+		}
+		last := first
+		for i := first; i < len(script); i++ {
+			if script[i].Op != Equal {
+				if i-last-1 > 2*opts.Context {
+					break
+				}
+				last = i
+			}
+		}
+
+		from := max(first-opts.Context, start)
+		to := min(last+opts.Context+1, len(script))
+
+		aStart, aCount := aPos[from], aPos[to]-aPos[from]
+		bStart, bCount := bPos[from], bPos[to]-bPos[from]
+		if aCount > 0 {
+			aStart++
+		}
+		if bCount > 0 {
+			bStart++
+		}
+		paint(colorCyan, fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount))
+
+		for _, line := range script[from:to] {
+			text := line.Text
+			noNewline := !strings.HasSuffix(text, "\n")
+			if noNewline {
+				text += "\n"
+			}
+
+			switch line.Op {
+			case Equal:
+				out.WriteString(" " + text)
+			case Delete:
+				paint(colorRed, "-"+text)
+			case Insert:
+				paint(colorGreen, "+"+text)
+			}
+			if noNewline {
+				out.WriteString("\\ No newline at end of file\n")
+			}
+		}
+
+		start = to
+	}
+
+	return out.String()
+}
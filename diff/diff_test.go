@@ -0,0 +1,210 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sides rebuilds the old and new texts from a script.
+func sides(script []Line) (a, b []string) {
+	for _, line := range script {
+		if line.Op != Insert {
+			a = append(a, line.Text)
+		}
+		if line.Op != Delete {
+			b = append(b, line.Text)
+		}
+	}
+	return a, b
+}
+
+// edits counts the lines a script deletes or inserts.
+func edits(script []Line) int {
+	n := 0
+	for _, line := range script {
+		if line.Op != Equal {
+			n++
+		}
+	}
+	return n
+}
+
+// distance is the length of a shortest edit script, from the longest
+// common subsequence.
+func distance(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return len(a) + len(b) - 2*lcs[0][0]
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", ""},
+		{"a", "a", "="},
+		{"a", "", "-"},
+		{"", "a", "+"},
+		{"abc", "abc", "==="},
+		{"abc", "axc", "=-+="},
+		{"abcabba", "cbabac", "-+=-==-=+"},
+		{"ab", "ba", "-=+"},
+	}
+	ops := map[Op]byte{Equal: '=', Delete: '-', Insert: '+'}
+
+	for _, tt := range tests {
+		a, b := strings.Split(tt.a, ""), strings.Split(tt.b, "")
+		script := Lines(a, b)
+		var got strings.Builder
+		for _, line := range script {
+			got.WriteByte(ops[line.Op])
+		}
+		if edits(script) != distance(a, b) {
+			t.Errorf("Lines(%q, %q) = %s is not a shortest script", tt.a, tt.b, got.String())
+		} else if got.String() != tt.want {
+			t.Errorf("Lines(%q, %q) = %s, want %s", tt.a, tt.b, got.String(), tt.want)
+		}
+	}
+}
+
+func TestLinesRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func() []string {
+		lines := make([]string, rng.Intn(40))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := random(), random()
+		script := Lines(a, b)
+		gotA, gotB := sides(script)
+		if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+			t.Fatalf("Lines(%q, %q) does not rebuild its inputs", a, b)
+		}
+		if got, want := edits(script), distance(a, b); got != want {
+			t.Fatalf("Lines(%q, %q) makes %d edits, want %d", a, b, got, want)
+		}
+	}
+}
+
+func TestLinesBeyondMaxCost(t *testing.T) {
+	a := make([]string, 3*maxCost)
+	b := make([]string, 3*maxCost)
+	for i := range a {
+		a[i] = "old"
+		b[i] = "new"
+		if i%3 == 0 {
+			a[i], b[i] = "same", "same"
+		}
+	}
+	gotA, gotB := sides(Lines(a, b))
+	if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+		t.Error("the script does not rebuild its inputs")
+	}
+}
+
+func TestLinesBeyondMaxCostKeepsUnchangedLines(t *testing.T) {
+	// Every other line changes, so a shortest script costs far more than
+	// maxCost and the search has to fall back on partial paths.
+	a := make([]string, 3*maxCost)
+	b := make([]string, 3*maxCost)
+	same := 0
+	for i := range a {
+		a[i], b[i] = fmt.Sprintf("old %d", i), fmt.Sprintf("new %d", i)
+		if i%2 == 0 {
+			a[i], b[i] = fmt.Sprintf("same %d", i), fmt.Sprintf("same %d", i)
+			same++
+		}
+	}
+	script := Lines(a, b)
+	gotA, gotB := sides(script)
+	if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+		t.Fatal("the script does not rebuild its inputs")
+	}
+	if got := len(script) - edits(script); got != same {
+		t.Errorf("kept %d unchanged lines, want %d", got, same)
+	}
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{"equal", "a\n", "a\n", 3, ""},
+		{"one change", "a\nb\nc\n", "a\nB\nc\n", 1, "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"no context", "a\nb\nc\n", "a\nB\nc\n", 0, "@@ -2,1 +2,1 @@\n-b\n+B\n"},
+		{"insert into empty", "", "a\n", 3, "@@ -0,0 +1,1 @@\n+a\n"},
+		{"delete all", "a\n", "", 3, "@@ -1,1 +0,0 @@\n-a\n"},
+		{"pure insert", "a\nb\n", "a\nx\nb\n", 0, "@@ -1,0 +2,1 @@\n+x\n"},
+		{
+			"separate hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n", "one\n2\n3\n4\n5\n6\n7\neight\n", 1,
+			"@@ -1,2 +1,2 @@\n-1\n+one\n 2\n@@ -7,2 +7,2 @@\n 7\n-8\n+eight\n",
+		},
+		{
+			"joined hunks",
+			"1\n2\n3\n4\n", "one\n2\n3\nfour\n", 1,
+			"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n-4\n+four\n",
+		},
+		{"no newline", "a", "b", 3, "@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Unified(tt.a, tt.b, Options{FromName: "a", ToName: "b", Context: tt.context})
+			if tt.want != "" {
+				tt.want = "--- a\n+++ b\n" + tt.want
+			}
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedColor(t *testing.T) {
+	got := Unified("a\n", "b\n", Options{FromName: "a", ToName: "b", Color: true})
+	for _, want := range []string{colorRed + "-a" + colorReset + "\n", colorGreen + "+b" + colorReset + "\n", colorCyan + "@@"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q does not contain %q", got, want)
+		}
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a\n"}},
+		{"a\n\nb", []string{"a\n", "\n", "b"}},
+	}
+	for _, tt := range tests {
+		if got := SplitLines(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("SplitLines(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
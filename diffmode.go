@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"go-reloaded/diff"
+	"go-reloaded/processor"
+	"io"
+	"os"
+	"strings"
+)
+
+// readInput returns the contents of filename, or of stdin for stdio.
+func readInput(filename string) (string, error) {
+	if filename == stdio {
+		content, err := io.ReadAll(os.Stdin)
+		return string(content), err
+	}
+	content, err := os.ReadFile(filename)
+	return string(content), err
+}
+
+// showDiffs prints a unified diff between every input and its processed
+// version instead of writing anything. It reports whether all inputs could
+// be processed.
+func showDiffs(proc *processor.Processor, inputs []string, color bool) bool {
+	ok := true
+	for _, input := range inputs {
+		content, err := readInput(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
+			ok = false
+			continue
+		}
+
+		result := proc.Run(content)
+		for _, d := range result.Diagnostics {
+			printDiagnostic(displayName(input), d)
+		}
+		if result.Err() != nil {
+			ok = false
+			continue
+		}
+
+		fmt.Print(diff.Unified(content, result.Text, diff.Options{
+			FromName: displayName(input),
+			ToName:   displayName(input),
+			Context:  3,
+			Color:    color,
+		}))
+	}
+	return ok
+}
+
+// checkFiles reports every input that processing would change, with the
+// first line that differs, the way gofmt -l does for code. It reports
+// whether all inputs were already normalized and could be processed.
+func checkFiles(proc *processor.Processor, inputs []string) bool {
+	ok := true
+	for _, input := range inputs {
+		content, err := readInput(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
+			ok = false
+			continue
+		}
+
+		result := proc.Run(content)
+		for _, d := range result.Diagnostics {
+			printDiagnostic(displayName(input), d)
+		}
+		if result.Err() != nil {
+			ok = false
+			continue
+		}
+		if result.Text == content {
+			continue
+		}
+
+		ok = false
+		line, got, want := firstDifference(content, result.Text)
+		fmt.Printf("%s:%d: not normalized\n", displayName(input), line)
+		fmt.Printf("\t- %s\n\t+ %s\n", got, want)
+	}
+	return ok
+}
+
+// firstDifference returns the 1-based number of the first line where a and
+// b differ, along with that line from each.
+func firstDifference(a, b string) (line int, fromA, fromB string) {
+	linesA, linesB := diff.SplitLines(a), diff.SplitLines(b)
+	for i := 0; ; i++ {
+		var la, lb string
+		if i < len(linesA) {
+			la = linesA[i]
+		}
+		if i < len(linesB) {
+			lb = linesB[i]
+		}
+		if la != lb || i >= max(len(linesA), len(linesB)) {
+			return i + 1, strings.TrimSuffix(la, "\n"), strings.TrimSuffix(lb, "\n")
+		}
+	}
+}
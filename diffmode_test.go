@@ -0,0 +1,82 @@
+package main
+
+import (
+	"go-reloaded/processor"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+	f()
+	w.Close()
+	return <-done
+}
+
+func TestFirstDifference(t *testing.T) {
+	tests := []struct {
+		a, b         string
+		line         int
+		fromA, fromB string
+	}{
+		{"a\nb\n", "a\nB\n", 2, "b", "B"},
+		{"a\n", "a\nb\n", 2, "", "b"},
+		{"a\nb\n", "a\n", 2, "b", ""},
+		{"a  \n", "a\n", 1, "a  ", "a"},
+	}
+	for _, tt := range tests {
+		line, fromA, fromB := firstDifference(tt.a, tt.b)
+		if line != tt.line || fromA != tt.fromA || fromB != tt.fromB {
+			t.Errorf("firstDifference(%q, %q) = %d, %q, %q; want %d, %q, %q",
+				tt.a, tt.b, line, fromA, fromB, tt.line, tt.fromA, tt.fromB)
+		}
+	}
+}
+
+func TestCheckFiles(t *testing.T) {
+	dir := t.TempDir()
+	clean := writeFile(t, dir, "clean.txt", "Already fine, thanks.\n")
+	dirty := writeFile(t, dir, "dirty.txt", "Fine.\nnot fine ,here\n")
+	missing := filepath.Join(dir, "missing.txt")
+	proc := processor.New()
+
+	var ok bool
+	out := captureStdout(t, func() { ok = checkFiles(proc, []string{clean}) })
+	if !ok || out != "" {
+		t.Errorf("clean file: got %v and %q, want true and no output", ok, out)
+	}
+
+	out = captureStdout(t, func() { ok = checkFiles(proc, []string{clean, dirty}) })
+	want := dirty + ":2: not normalized\n\t- not fine ,here\n\t+ not fine, here\n"
+	if ok || out != want {
+		t.Errorf("dirty file: got %v and %q, want false and %q", ok, out, want)
+	}
+
+	captureStdout(t, func() { ok = checkFiles(proc, []string{missing}) })
+	if ok {
+		t.Error("a missing file passed the check")
+	}
+
+	strict := processor.New(processor.WithStrict(true))
+	bad := writeFile(t, dir, "bad.txt", "zz (hex)\n")
+	if out := captureStdout(t, func() { ok = checkFiles(strict, []string{bad}) }); ok || strings.Contains(out, "not normalized") {
+		t.Errorf("strict errors: got %v and %q, want false and no listing", ok, out)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-reloaded/processor"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// inPlaceFlag is the value of -i / --in-place. It works as a plain switch
+// ("-i") or takes a backup suffix ("-i=.bak").
+type inPlaceFlag struct {
+	enabled bool
+	suffix  string
+}
+
+func (f *inPlaceFlag) String() string {
+	if f == nil || !f.enabled {
+		return ""
+	}
+	return f.suffix
+}
+
+func (f *inPlaceFlag) Set(value string) error {
+	switch value {
+	case "true":
+		f.enabled, f.suffix = true, ""
+	case "false":
+		f.enabled, f.suffix = false, ""
+	default:
+		f.enabled, f.suffix = true, value
+	}
+	return nil
+}
+
+func (f *inPlaceFlag) IsBoolFlag() bool {
+	return true
+}
+
+// rewriteFile replaces path with its processed version. The result is
+// written to a temporary file in the same directory, given the original
+// permissions and renamed over path, so the file is never left half
+// written. With a backup suffix the original is kept as path+suffix.
+func rewriteFile(proc *processor.Processor, path, backupSuffix string, report func(processor.Diagnostic)) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	input, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading input file: %w", err)
+	}
+	defer input.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = proc.RunStream(context.Background(), input, tmp, report)
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if backupSuffix != "" {
+		if err := backupFile(path, path+backupSuffix); err != nil {
+			return fmt.Errorf("writing backup: %w", err)
+		}
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// backupFile makes backup a copy of path, as a hard link when possible.
+func backupFile(path, backup string) error {
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if os.Link(path, backup) == nil {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
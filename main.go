@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"go-reloaded/processor"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 )
 
@@ -24,68 +29,277 @@ func isValidTxtFile(filename string) bool {
 	return true
 }
 
+// printDiagnostic writes a diagnostic to stderr in the gcc format
+// "file:line:col: severity: message" that editors know how to jump to.
+func printDiagnostic(filename string, d processor.Diagnostic) {
+	fmt.Fprintf(os.Stderr, "%s:%d:%d: %s: %s [%s]\n", filename, d.Line, d.Column, d.Severity, d.Message, d.Code)
+}
+
+// stdio is the file name that stands for stdin or stdout.
+const stdio = "-"
+
+// displayName is the name used for a file in messages.
+func displayName(filename string) string {
+	if filename == stdio {
+		return "<stdin>"
+	}
+	return filename
+}
+
+// processFile streams inputFile through proc into outputFile, either of
+// which may be stdio, passing diagnostics to report. A partially written
+// output file is removed on error.
+func processFile(proc *processor.Processor, inputFile, outputFile string, report func(processor.Diagnostic)) error {
+	input := os.Stdin
+	if inputFile != stdio {
+		f, err := os.Open(inputFile)
+		if err != nil {
+			return fmt.Errorf("reading input file: %w", err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	output := os.Stdout
+	if outputFile != stdio {
+		f, err := os.Create(outputFile)
+		if err != nil {
+			return fmt.Errorf("writing output file: %w", err)
+		}
+		output = f
+	}
+
+	err := proc.RunStream(context.Background(), input, output, report)
+	if outputFile != stdio {
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(outputFile)
+		}
+	}
+	return err
+}
+
+// processHeld is processFile for output that cannot be taken back once
+// written, such as stdout: the result is held in a temporary file and only
+// copied to w once the whole input has been processed without errors.
+func processHeld(proc *processor.Processor, inputFile string, w io.Writer, report func(processor.Diagnostic)) error {
+	tmp, err := os.CreateTemp("", "go-reloaded-*.txt")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := processFile(proc, inputFile, tmp.Name(), report); err != nil {
+		return err
+	}
+
+	held, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer held.Close()
+	_, err = io.Copy(w, held)
+	return err
+}
+
 func main() {
+	strict := flag.Bool("strict", false, "treat ambiguous or invalid markers as errors")
+	thousands := flag.String("thousands", "", "group the digits of converted numbers with `sep`, e.g. ','")
+	precision := flag.Int("precision", 10, "maximum `digits` after the point in converted fractions")
+	outDir := flag.String("o", "", "batch mode: write results under `dir`, mirroring the inputs")
+	var dirs stringList
+	flag.Var(&dirs, "r", "batch mode: process every .txt file under `dir` (repeatable)")
+	workers := flag.Int("j", runtime.NumCPU(), "batch mode: number of files processed in parallel")
+	var inPlace inPlaceFlag
+	flag.Var(&inPlace, "i", "edit files in place; -i=SUFFIX keeps a backup with that suffix")
+	flag.Var(&inPlace, "in-place", "same as -i")
+	showDiff := flag.Bool("diff", false, "print a unified diff of the changes instead of writing files")
+	color := flag.Bool("color", false, "colorize --diff output")
+	check := flag.Bool("check", false, "exit with status 1 if any file would be changed, listing those files")
+	trace := flag.Bool("trace", false, "print what each marker and rule changed instead of writing files")
+	reportFormat := flag.String("report", "", "print a report of every change in `format` (json) instead of writing files")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [--strict] [input_file|-] [output_file|-]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [--strict] [-j n] [-r dir]... -o dir [file|glob|dir]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [--strict] [-j n] -i[=SUFFIX] [-r dir]... [file|glob|dir]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [--strict] --diff [--color] [-r dir]... [file|glob|dir|-]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [--strict] --check [-r dir]... [file|glob|dir|-]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [--strict] --trace [-r dir]... [file|glob|dir|-]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [--strict] --report json [-r dir]... [file|glob|dir|-]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Reads stdin and writes stdout when a file is \"-\" or left out.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	proc := processor.New(
+		processor.WithStrict(*strict),
+		processor.WithTrace(*trace),
+		processor.WithThousandsSeparator(*thousands),
+		processor.WithPrecision(*precision),
+	)
+
+	if *trace {
+		inputs, err := inputFiles(dirs, flag.Args())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !showTraces(proc, inputs) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *reportFormat != "" {
+		if *reportFormat != "json" {
+			fmt.Fprintf(os.Stderr, "Error: unknown report format %q (want json)\n", *reportFormat)
+			os.Exit(1)
+		}
+		if *showDiff || *check {
+			fmt.Fprintf(os.Stderr, "Error: --report cannot be combined with --diff or --check\n")
+			os.Exit(1)
+		}
+		inputs, err := inputFiles(dirs, flag.Args())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !writeReport(proc, inputs) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *showDiff || *check {
+		inputs, err := inputFiles(dirs, flag.Args())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		ok := true
+		if *check {
+			ok = checkFiles(proc, inputs)
+		}
+		if *showDiff {
+			ok = showDiffs(proc, inputs, *color) && ok
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if inPlace.enabled {
+		if *outDir != "" {
+			fmt.Fprintf(os.Stderr, "Error: -i and -o cannot be used together\n")
+			os.Exit(1)
+		}
+		jobs, err := collectJobs(dirs, flag.Args(), "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for i := range jobs {
+			jobs[i].backup = inPlace.suffix
+		}
+		if !runBatch(proc, jobs, *workers, true) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *outDir != "" || len(dirs) > 0 {
+		if *outDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -r needs an output directory (-o)\n")
+			os.Exit(1)
+		}
+		jobs, err := collectJobs(dirs, flag.Args(), *outDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !runBatch(proc, jobs, *workers, false) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Check command line arguments
-	if len(os.Args) != 3 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <input_file> <output_file>\n", os.Args[0])
+	inputFile, outputFile := stdio, stdio
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		inputFile = flag.Arg(0)
+	case 2:
+		inputFile, outputFile = flag.Arg(0), flag.Arg(1)
+	default:
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	inputFile := os.Args[1]
-	outputFile := os.Args[2]
-
 	// Validate file extensions
-	if !isValidTxtFile(inputFile) {
+	if inputFile != stdio && !isValidTxtFile(inputFile) {
 		fmt.Fprintf(os.Stderr, "Error: Input file must have .txt extension\n")
 		os.Exit(1)
 	}
-	if !isValidTxtFile(outputFile) {
+	if outputFile != stdio && !isValidTxtFile(outputFile) {
 		fmt.Fprintf(os.Stderr, "Error: Output file must have .txt extension\n")
 		os.Exit(1)
 	}
 
 	// Check if input and output files are the same
-	absInputPath, err := filepath.Abs(inputFile)
-	if err == nil {
-		absOutputPath, err := filepath.Abs(outputFile)
-		if err == nil && absInputPath == absOutputPath {
-			fmt.Fprintf(os.Stderr, "Error: Input and output files cannot be the same\n")
-			os.Exit(1)
+	if inputFile != stdio && outputFile != stdio {
+		absInputPath, err := filepath.Abs(inputFile)
+		if err == nil {
+			absOutputPath, err := filepath.Abs(outputFile)
+			if err == nil && absInputPath == absOutputPath {
+				fmt.Fprintf(os.Stderr, "Error: Input and output files cannot be the same (use -i to edit in place)\n")
+				os.Exit(1)
+			}
 		}
 	}
 
 	// Check if input file exists
-	_, err = os.Stat(inputFile)
-	if os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Error: Input file '%s' does not exist\n", inputFile)
-		os.Exit(1)
+	if inputFile != stdio {
+		if _, err := os.Stat(inputFile); os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error: Input file '%s' does not exist\n", inputFile)
+			os.Exit(1)
+		}
 	}
 
 	// Check if output file is writable
-	outputDir := filepath.Dir(outputFile)
-	if outputDir == "." {
-		outputDir = "."
-	}
-	info, err := os.Stat(outputDir)
-	if err != nil || !info.IsDir() {
-		fmt.Fprintf(os.Stderr, "Error: Output directory '%s' does not exist\n", outputDir)
-		os.Exit(1)
+	if outputFile != stdio {
+		outputDir := filepath.Dir(outputFile)
+		info, err := os.Stat(outputDir)
+		if err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Error: Output directory '%s' does not exist\n", outputDir)
+			os.Exit(1)
+		}
 	}
 
-	// Read input file
-	content, err := os.ReadFile(inputFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
-		os.Exit(1)
+	// Process the input line by line so that large files are never held in
+	// memory as a whole
+	report := func(d processor.Diagnostic) {
+		printDiagnostic(displayName(inputFile), d)
+	}
+	// In strict mode nothing may reach stdout when there are errors, so the
+	// output is held back until the whole input has been processed.
+	var err error
+	if *strict && outputFile == stdio {
+		err = processHeld(proc, inputFile, os.Stdout, report)
+	} else {
+		err = processFile(proc, inputFile, outputFile, report)
 	}
-
-	// Process the content using the processor package
-	processedText := processor.ProcessText(string(content))
-
-	// Write to output file
-	err = os.WriteFile(outputFile, []byte(processedText), 0644)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing output file: %v\n", err)
+		var diagErr *processor.DiagnosticsError
+		if errors.As(err, &diagErr) {
+			fmt.Fprintf(os.Stderr, "Error: output not written because of errors in %s\n", displayName(inputFile))
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"go-reloaded/processor"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile creates name under dir with the given content and returns its
+// path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProcessHeld(t *testing.T) {
+	dir := t.TempDir()
+	strict := processor.New(processor.WithStrict(true))
+
+	var out strings.Builder
+	input := writeFile(t, dir, "bad.txt", "zz (hex)\nok (up)\n")
+	err := processHeld(strict, input, &out, nil)
+	var diagErr *processor.DiagnosticsError
+	if !errors.As(err, &diagErr) {
+		t.Errorf("got %v, want a DiagnosticsError", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("output was written despite errors: %q", out.String())
+	}
+
+	out.Reset()
+	input = writeFile(t, dir, "good.txt", "ff (hex)\nok (up)\n")
+	if err := processHeld(strict, input, &out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "255\nOK\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestProcessFileRemovesOutputOnError(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "in.txt", "zz (hex)\n")
+	output := filepath.Join(dir, "out.txt")
+
+	err := processFile(processor.New(processor.WithStrict(true)), input, output, nil)
+	if err == nil {
+		t.Fatal("got no error in strict mode")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output file left behind: %v", err)
+	}
+}
+
+func TestIsValidTxtFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"notes.txt", true},
+		{"dir/NOTES.TXT", true},
+		{".txt", false},
+		{".hidden.txt", false},
+		{"notes.md", false},
+	}
+	for _, tt := range tests {
+		if got := isValidTxtFile(tt.name); got != tt.want {
+			t.Errorf("isValidTxtFile(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// maxPowerBits caps the size of a power, so that "9 ^ 9 ^ 9" fails instead
+// of exhausting memory.
+const maxPowerBits = 1 << 20
+
+// calcMarker implements "(calc)": it evaluates an arithmetic expression
+// with + - * / % ^ and parentheses, exactly, over rational numbers.
+// Numbers may have a fraction or a 0x, 0o or 0b prefix.
+func calcMarker(call *Call, words []string) ([]string, error) {
+	p := &calcParser{call: call}
+	for _, word := range words {
+		// A sign glued to a number is the minus operator: "3 -2" is 1.
+		if len(word) > 1 && word[0] == '-' {
+			p.tokens = append(p.tokens, "-")
+			word = word[1:]
+		}
+		p.tokens = append(p.tokens, word)
+	}
+
+	value, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+
+	result := groupThousands(formatRadix(value, 10, call.precision), call.thousands)
+	return []string{result}, nil
+}
+
+// calcParser is a recursive descent parser that evaluates as it goes.
+// From loosest to tightest: + and -, then * / and %, then unary signs,
+// then ^, which groups to the right.
+type calcParser struct {
+	call   *Call
+	tokens []string
+	pos    int
+}
+
+func (p *calcParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *calcParser) expression() (*big.Rat, error) {
+	value, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == "+" || op == "-"; op = p.peek() {
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			value.Add(value, right)
+		} else {
+			value.Sub(value, right)
+		}
+	}
+	return value, nil
+}
+
+func (p *calcParser) term() (*big.Rat, error) {
+	value, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == "*" || op == "/" || op == "%"; op = p.peek() {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if op != "*" && right.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+
+		switch op {
+		case "*":
+			value.Mul(value, right)
+		case "/":
+			value.Quo(value, right)
+		case "%":
+			// The remainder takes the sign of the dividend, as in Go.
+			quotient := new(big.Rat).Quo(value, right)
+			whole := new(big.Int).Quo(quotient.Num(), quotient.Denom())
+			value.Sub(value, new(big.Rat).Mul(right, new(big.Rat).SetInt(whole)))
+		}
+	}
+	return value, nil
+}
+
+func (p *calcParser) unary() (*big.Rat, error) {
+	switch p.peek() {
+	case "-":
+		p.pos++
+		value, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return value.Neg(value), nil
+	case "+":
+		p.pos++
+		return p.unary()
+	}
+	return p.power()
+}
+
+func (p *calcParser) power() (*big.Rat, error) {
+	base, err := p.primary()
+	if err != nil || p.peek() != "^" {
+		return base, err
+	}
+	p.pos++
+	exponent, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	if !exponent.IsInt() || !exponent.Num().IsInt64() {
+		return nil, fmt.Errorf("the exponent %s is not a whole number", exponent.RatString())
+	}
+	n := exponent.Num().Int64()
+	if n < 0 && base.Sign() == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	size := int64(base.Num().BitLen() + base.Denom().BitLen())
+	if n > maxPowerBits || n < -maxPowerBits || size*max(n, -n) > maxPowerBits {
+		return nil, fmt.Errorf("the result of %s ^ %d is too large", base.RatString(), n)
+	}
+
+	e := big.NewInt(max(n, -n))
+	result := new(big.Rat).SetFrac(new(big.Int).Exp(base.Num(), e, nil), new(big.Int).Exp(base.Denom(), e, nil))
+	if n < 0 {
+		result.Inv(result)
+	}
+	return result, nil
+}
+
+func (p *calcParser) primary() (*big.Rat, error) {
+	token := p.peek()
+	p.pos++
+
+	switch token {
+	case "":
+		return nil, fmt.Errorf("the expression ends too soon")
+	case "(":
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return value, nil
+	}
+
+	base := 10
+	for b, prefix := range literalPrefixes {
+		if len(token) > len(prefix) && strings.EqualFold(token[:len(prefix)], prefix) {
+			base = b
+		}
+	}
+	value, ok := parseLiteral(token, base, p.call.thousands)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	return value, nil
+}
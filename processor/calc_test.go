@@ -0,0 +1,35 @@
+package processor
+
+import "testing"
+
+func TestCalcMarker(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"The answer is (3 * 14) (calc).", "The answer is 42."},
+		{"total 3 + 4 (calc) items", "total 7 items"},
+		{"2 + 3 * 4 (calc)", "14"},
+		{"(2 + 3) * 4 (calc)", "20"},
+		{"10 - 20 (calc)", "-10"},
+		{"2 ^ 10 (calc)", "1024"},
+		{"-2 ^ 2 (calc)", "-4"},
+		{"2 ^ -1 (calc)", "0.5"},
+		{"7 % 3 (calc)", "1"},
+		{"1 / 4 (calc)", "0.25"},
+		{"1 / 3 (calc)", "0.3333333333"},
+		{"1.5 + 2.25 (calc)", "3.75"},
+		{"0x10 + 0b1 (calc)", "17"},
+		{"(FF (hex) + 1) (calc)", "256"},
+	})
+}
+
+func TestCalcErrors(t *testing.T) {
+	runInvalid(t, New(), []struct{ in, message string }{
+		{"1 / 0 (calc)", "division by zero"},
+		{"2 ^ 0.5 (calc)", "the exponent 1/2 is not a whole number"},
+		{"2 ^ 99999999 (calc)", "the result of 2 ^ 99999999 is too large"},
+		{"(1 + (calc)", "the expression ends too soon"},
+	})
+
+	if r := Run("words only (calc)"); len(r.Diagnostics) != 1 || r.Diagnostics[0].Code != CodeNoTarget {
+		t.Errorf("got %v, want one %s diagnostic", r.Diagnostics, CodeNoTarget)
+	}
+}
@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Severity tells how serious a diagnostic is.
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Diagnostic codes.
+const (
+	// CodeNoTarget: the marker has no word it could apply to.
+	CodeNoTarget = "no-target"
+
+	// CodeUnclosedMarker: a marker is missing its closing parenthesis.
+	CodeUnclosedMarker = "unclosed-marker"
+
+	// CodeUnmatchedBlock: a (raw) or "(up:begin)" block is never closed, or
+	// a closing tag has nothing to close.
+	CodeUnmatchedBlock = "unmatched-block"
+
+	// CodeUnknownMarker: a chain such as "(up|bogus)" names a marker that
+	// is not registered.
+	CodeUnknownMarker = "unknown-marker"
+
+	// CodeBadArguments: a known marker was given arguments it does not take.
+	CodeBadArguments = "bad-arguments"
+
+	// CodeBadCount: the N of "(cmd, N)" is not positive, is capped, or is
+	// larger than the number of words available.
+	CodeBadCount = "bad-count"
+
+	// CodeRetargeted: a case marker after a number was applied to an
+	// earlier word instead.
+	CodeRetargeted = "retargeted"
+
+	// CodeInvalidTarget: the marker rejected the words it was applied to,
+	// e.g. (hex) after a word that is not hexadecimal.
+	CodeInvalidTarget = "invalid-target"
+)
+
+// Diagnostic reports a problem found while processing text. Offset is a
+// byte offset into the input; Line and Column are 1-based, and Column
+// counts characters.
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Message  string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// String formats the diagnostic as "line:col: severity: message [code]".
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s [%s]", d.Line, d.Column, d.Severity, d.Message, d.Code)
+}
+
+type diagnostics []Diagnostic
+
+func (ds *diagnostics) add(severity Severity, code string, offset int, format string, args ...any) {
+	*ds = append(*ds, Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Offset:   offset,
+	})
+}
+
+// locate fills in Line and Column from Offset and sorts the diagnostics by
+// position in text.
+func (ds diagnostics) locate(text string) []Diagnostic {
+	sort.SliceStable(ds, func(i, j int) bool {
+		return ds[i].Offset < ds[j].Offset
+	})
+
+	for i := range ds {
+		ds[i].Line, ds[i].Column = position(text, ds[i].Offset)
+	}
+	return ds
+}
+
+// HasErrors reports whether any of ds has error severity.
+func HasErrors(ds []Diagnostic) bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// DiagnosticsError is returned when processing produced error diagnostics.
+// First is the earliest of them and Count how many there were.
+type DiagnosticsError struct {
+	First Diagnostic
+	Count int
+}
+
+func (e *DiagnosticsError) Error() string {
+	if e.Count == 1 {
+		return e.First.String()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e.First, e.Count-1)
+}
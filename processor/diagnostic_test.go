@@ -0,0 +1,84 @@
+package processor
+
+import "testing"
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		in     string
+		code   string
+		line   int
+		column int
+	}{
+		{"(up) word", CodeNoTarget, 1, 1},
+		{"42 (cap)", CodeNoTarget, 1, 4},
+		{"word (up", CodeUnclosedMarker, 1, 6},
+		{"word (up, x)", CodeBadArguments, 1, 6},
+		{"word (up, 0)", CodeBadCount, 1, 6},
+		{"one two (up, 5)", CodeBadCount, 1, 9},
+		{"name 42 (cap)", CodeRetargeted, 1, 9},
+		{"zz (hex)", CodeInvalidTarget, 1, 4},
+		{"line one\n  two (low, 0)", CodeBadCount, 2, 7},
+		{"héllo wörld (up, x)", CodeBadArguments, 1, 13},
+	}
+
+	for _, tt := range tests {
+		r := Run(tt.in)
+		if len(r.Diagnostics) != 1 {
+			t.Errorf("Run(%q) gave %d diagnostics, want 1: %v", tt.in, len(r.Diagnostics), r.Diagnostics)
+			continue
+		}
+		d := r.Diagnostics[0]
+		if d.Code != tt.code || d.Line != tt.line || d.Column != tt.column || d.Severity != SeverityWarning {
+			t.Errorf("Run(%q) = %s, want a %s warning at %d:%d", tt.in, d, tt.code, tt.line, tt.column)
+		}
+	}
+}
+
+func TestNoDiagnostics(t *testing.T) {
+	for _, in := range []string{
+		"plain text",
+		"(cap in hand)",
+		"(foo) and (bar, 2)",
+		"it (up) works",
+	} {
+		if r := Run(in); len(r.Diagnostics) != 0 {
+			t.Errorf("Run(%q) = %v, want no diagnostics", in, r.Diagnostics)
+		}
+	}
+}
+
+func TestDiagnosticsAreSorted(t *testing.T) {
+	r := Run("zz (hex) and (up) x (up, 0)")
+	for i := 1; i < len(r.Diagnostics); i++ {
+		if r.Diagnostics[i-1].Offset > r.Diagnostics[i].Offset {
+			t.Errorf("diagnostics out of order: %v", r.Diagnostics)
+		}
+	}
+}
+
+func TestDiagnosticString(t *testing.T) {
+	d := Diagnostic{Severity: SeverityWarning, Code: CodeNoTarget, Message: "(up) has no word", Line: 3, Column: 7}
+	if got, want := d.String(), "3:7: warning: (up) has no word [no-target]"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestHasErrors(t *testing.T) {
+	warning := Diagnostic{Severity: SeverityWarning}
+	if HasErrors([]Diagnostic{warning}) {
+		t.Error("a warning counted as an error")
+	}
+	if !HasErrors([]Diagnostic{warning, {Severity: SeverityError}}) {
+		t.Error("an error was missed")
+	}
+}
+
+func TestDiagnosticsError(t *testing.T) {
+	first := Diagnostic{Severity: SeverityError, Code: "c", Message: "m", Line: 1, Column: 2}
+	if got, want := (&DiagnosticsError{First: first, Count: 1}).Error(), "1:2: error: m [c]"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := (&DiagnosticsError{First: first, Count: 3}).Error(), "1:2: error: m [c] (and 2 more errors)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
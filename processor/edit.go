@@ -0,0 +1,87 @@
+package processor
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Edit is one change made while processing text. Rule is the name of the
+// marker that made it, such as "hex" or "up", or of the formatting Rule,
+// such as "articles" or "punctuation".
+//
+// Original is the text as the rule found it and Replacement what it became.
+// When a formatting rule changed the whitespace before a token, both start
+// with that whitespace, so " ," -> "," is a space removed before a comma.
+// A marker removed from the text is an edit of its own rule, whose Original
+// takes along the whitespace removed after it.
+// Offset, Line and Column locate the token in the input, as for a
+// Diagnostic.
+type Edit struct {
+	Rule        string `json:"rule"`
+	Original    string `json:"original"`
+	Replacement string `json:"replacement"`
+	Offset      int    `json:"offset"`
+	Line        int    `json:"line"`
+	Column      int    `json:"column"`
+}
+
+type edits []Edit
+
+func (es *edits) add(rule string, offset int, original, replacement string) {
+	*es = append(*es, Edit{
+		Rule:        rule,
+		Original:    original,
+		Replacement: replacement,
+		Offset:      offset,
+	})
+}
+
+// record compares the tokens before and after a formatting pass and adds
+// an edit for every token whose text or preceding whitespace changed.
+// Tokens are matched by position, so a pass may drop tokens it merged into
+// their neighbours.
+func (es *edits) record(rule Rule, before, after []token) {
+	j := 0
+	for _, tok := range after {
+		for j < len(before) && before[j].pos < tok.pos {
+			j++
+		}
+		if j == len(before) {
+			return
+		}
+		old := before[j]
+		if old.pos != tok.pos {
+			continue
+		}
+
+		switch {
+		case old.space != tok.space:
+			es.add(string(rule), tok.pos, old.space+old.text, tok.space+tok.text)
+		case old.text != tok.text:
+			es.add(string(rule), tok.pos, old.text, tok.text)
+		}
+	}
+}
+
+// locate fills in Line and Column from Offset and sorts the edits by
+// position in text, keeping edits to the same token in the order they were
+// made.
+func (es edits) locate(text string) []Edit {
+	sort.SliceStable(es, func(i, j int) bool {
+		return es[i].Offset < es[j].Offset
+	})
+
+	for i := range es {
+		es[i].Line, es[i].Column = position(text, es[i].Offset)
+	}
+	return es
+}
+
+// position returns the 1-based line and column of a byte offset in text.
+// Columns count characters.
+func position(text string, offset int) (line, column int) {
+	before := text[:offset]
+	lineStart := strings.LastIndex(before, "\n") + 1
+	return strings.Count(before, "\n") + 1, utf8.RuneCountInString(before[lineStart:]) + 1
+}
@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEdits(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []Edit
+	}{
+		{"nothing to do", nil},
+		{"ff (hex) ,a apple", []Edit{
+			{"hex", "ff", "255", 0, 1, 1},
+			{"hex", "(hex) ", "", 3, 1, 4},
+			{"punctuation", " ,", ",", 9, 1, 10},
+			{"punctuation", "a", " a", 10, 1, 11},
+			{"articles", "a", "an", 10, 1, 11},
+		}},
+		{"héllo\nworld (up)", []Edit{
+			{"up", "world", "WORLD", 7, 2, 1},
+			{"spaces", " ", "", 12, 2, 6},
+			{"up", "(up)", "", 13, 2, 7},
+		}},
+		{"one two (up, 2)", []Edit{
+			{"up", "one", "ONE", 0, 1, 1},
+			{"up", "two", "TWO", 4, 1, 5},
+			{"spaces", " ", "", 7, 1, 8},
+			{"up", "(up, 2)", "", 8, 1, 9},
+		}},
+		{"one(low)two", []Edit{
+			{"low", "(low)", " ", 3, 1, 4},
+		}},
+		{"'two words' (up)", []Edit{
+			{"up", "two", "TWO", 1, 1, 2},
+			{"up", "words", "WORDS", 5, 1, 6},
+			{"spaces", " ", "", 11, 1, 12},
+			{"up", "(up)", "", 12, 1, 13},
+		}},
+	}
+
+	for _, tt := range tests {
+		if got := Run(tt.in).Edits; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Run(%q).Edits\n got %+v\nwant %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPosition(t *testing.T) {
+	text := "ab\nçd\n\nx"
+	tests := []struct{ offset, line, column int }{
+		{0, 1, 1},
+		{2, 1, 3},
+		{3, 2, 1},
+		{5, 2, 2},
+		{7, 3, 1},
+		{8, 4, 1},
+	}
+	for _, tt := range tests {
+		if line, column := position(text, tt.offset); line != tt.line || column != tt.column {
+			t.Errorf("position(%d) = %d:%d, want %d:%d", tt.offset, line, column, tt.line, tt.column)
+		}
+	}
+}
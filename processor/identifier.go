@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"strings"
+	"unicode"
+)
+
+// identifierMarker builds the markers that join their target words into one
+// identifier: "(snake, 3)" turns "user account id" into "user_account_id".
+// Words that are already identifiers are split first, so "userAccountId
+// (snake)" gives the same result.
+func identifierMarker(style string) func(call *Call, words []string) ([]string, error) {
+	return func(call *Call, words []string) ([]string, error) {
+		var parts []string
+		for _, word := range words {
+			parts = append(parts, splitIdentifier(word)...)
+		}
+		if len(parts) == 0 {
+			return words, nil
+		}
+
+		for i, part := range parts {
+			switch {
+			case style == "constant":
+				parts[i] = call.Upper(part)
+			case style == "pascal" || style == "camel" && i > 0:
+				parts[i] = call.Capitalize(part)
+			default:
+				parts[i] = call.Lower(part)
+			}
+		}
+
+		switch style {
+		case "snake", "constant":
+			return []string{strings.Join(parts, "_")}, nil
+		case "kebab":
+			return []string{strings.Join(parts, "-")}, nil
+		}
+		return []string{strings.Join(parts, "")}, nil
+	}
+}
+
+// splitIdentifier breaks a word into its parts at underscores, hyphens and
+// other separators, and where the case changes: "parseHTTPRequest" gives
+// "parse", "HTTP" and "Request". Digits stay with the part before them.
+func splitIdentifier(word string) []string {
+	var parts []string
+	runes := []rune(word)
+	start := 0
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if i > start {
+				parts = append(parts, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i == start || !unicode.IsUpper(r) {
+			continue
+		}
+
+		prev := runes[i-1]
+		nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && nextLower {
+			parts = append(parts, string(runes[start:i]))
+			start = i
+		}
+	}
+	if start < len(runes) {
+		parts = append(parts, string(runes[start:]))
+	}
+	return parts
+}
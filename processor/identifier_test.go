@@ -0,0 +1,48 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIdentifierMarkers(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"user account id (snake, 3)", "user_account_id"},
+		{"user account id (camel, 3)", "userAccountId"},
+		{"user account id (pascal, 3)", "UserAccountId"},
+		{"user account id (kebab, 3)", "user-account-id"},
+		{"user account id (constant, 3)", "USER_ACCOUNT_ID"},
+		{"parseHTTPRequest (snake)", "parse_http_request"},
+		{"user_account_id (camel)", "userAccountId"},
+		{"XMLHttpRequest (kebab)", "xml-http-request"},
+		{"some-name (constant)", "SOME_NAME"},
+		{"Über Straße (snake, 2)", "über_straße"},
+		{"'user account' (camel)", "'userAccount'"},
+		{"(>snake, 2) hello world", "hello_world"},
+	})
+}
+
+func TestIdentifiersStopAtPunctuation(t *testing.T) {
+	runInvalid(t, New(), []struct{ in, message string }{
+		{"hello, world (snake, 2)", `cannot join words separated by ","`},
+		{"'user account' id (snake, 3)", `cannot join words separated by "'"`},
+	})
+}
+
+func TestSplitIdentifier(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"parseHTTPRequest", []string{"parse", "HTTP", "Request"}},
+		{"user_account-id", []string{"user", "account", "id"}},
+		{"version2Beta", []string{"version2", "Beta"}},
+		{"ID", []string{"ID"}},
+		{"__x__", []string{"x"}},
+	}
+	for _, tt := range tests {
+		if got := splitIdentifier(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitIdentifier(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
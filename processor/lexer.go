@@ -0,0 +1,493 @@
+package processor
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokNumber
+	tokPunct
+	tokQuote
+	tokSpace
+	tokNewline
+	tokMarker
+	tokSymbol
+	tokRaw
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokWord:
+		return "word"
+	case tokNumber:
+		return "number"
+	case tokPunct:
+		return "punctuation"
+	case tokQuote:
+		return "quote"
+	case tokSpace:
+		return "whitespace"
+	case tokNewline:
+		return "newline"
+	case tokMarker:
+		return "marker"
+	case tokRaw:
+		return "raw text"
+	default:
+		return "symbol"
+	}
+}
+
+// token is a single lexical unit of the input. pos is the byte offset of
+// the token in the original text and never changes when text is rewritten.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+
+	// Only set for tokMarker. block is blockBegin or blockEnd for the tags
+	// of a "(up:begin) ... (up:end)" block.
+	name    string
+	args    []string
+	forward bool
+	block   string
+
+	// space is the whitespace printed before the token once the token
+	// stream has been laid out for formatting.
+	space string
+}
+
+func (t token) isWord() bool {
+	return t.kind == tokWord || t.kind == tokNumber
+}
+
+// lex splits text into tokens. Parenthesized groups are only turned into
+// marker tokens when markers are enabled, the command name is a known
+// marker and the arguments fit; anything else is left as ordinary symbols
+// and words. "\(" is a literal parenthesis, and the contents of a
+// (raw)...(/raw) block become a single raw token that no rule touches.
+func lex(text string, cfg *config, diags *diagnostics) []token {
+	var tokens []token
+
+	i := 0
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		start := i
+
+		switch {
+		case r == '\n':
+			i += size
+			tokens = append(tokens, token{kind: tokNewline, text: "\n", pos: start})
+
+		case r == ' ' || r == '\t' || r == '\r':
+			for i < len(text) && (text[i] == ' ' || text[i] == '\t' || text[i] == '\r') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokSpace, text: text[start:i], pos: start})
+
+		case r == '\\' && strings.HasPrefix(text[i+1:], "("):
+			i += 2
+			tokens = append(tokens, token{kind: tokSymbol, text: "(", pos: start + 1})
+
+		case hasPrefixFold(text[i:], rawOpen):
+			end, ok := rawBlockEnd(text, i)
+			if !ok {
+				diags.add(SeverityWarning, CodeUnmatchedBlock, i, "%s has no matching %s", rawOpen, rawClose)
+				i += len(rawOpen)
+				tokens = append(tokens, token{kind: tokSymbol, text: text[start:i], pos: start})
+				break
+			}
+			tokens = append(tokens, token{kind: tokRaw, text: text[i+len(rawOpen) : end-len(rawClose)], pos: i + len(rawOpen)})
+			i = end
+
+		case hasPrefixFold(text[i:], rawClose):
+			diags.add(SeverityWarning, CodeUnmatchedBlock, i, "%s has no matching %s", rawClose, rawOpen)
+			i += len(rawClose)
+			tokens = append(tokens, token{kind: tokSymbol, text: text[start:i], pos: start})
+
+		case r == '(':
+			if marker, ok := scanMarker(text, i, cfg.registry); ok && cfg.enabled(RuleMarkers) {
+				i += len(marker.text)
+				tokens = append(tokens, marker)
+			} else {
+				if cfg.enabled(RuleMarkers) {
+					diagnoseMarker(text, i, cfg.registry, diags)
+				}
+				i += size
+				tokens = append(tokens, token{kind: tokSymbol, text: "(", pos: start})
+			}
+
+		case cfg.isQuote(r):
+			i += size
+			tokens = append(tokens, token{kind: tokQuote, text: text[start:i], pos: start})
+
+		case isPunctuation(byte(r)) && r < utf8.RuneSelf:
+			for i < len(text) && isPunctuation(text[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokPunct, text: text[start:i], pos: start})
+
+		case isWordRune(r) || isSign(text, i):
+			i = scanWord(text, i)
+			kind := tokWord
+			if isNumber(text[start:i]) {
+				kind = tokNumber
+			}
+			tokens = append(tokens, token{kind: kind, text: text[start:i], pos: start})
+
+		default:
+			i += size
+			tokens = append(tokens, token{kind: tokSymbol, text: text[start:i], pos: start})
+		}
+	}
+
+	return tokens
+}
+
+const (
+	rawOpen  = "(raw)"
+	rawClose = "(/raw)"
+
+	blockBegin = "begin"
+	blockEnd   = "end"
+)
+
+// rawBlockEnd returns the end of the (raw)...(/raw) block opening at i, just
+// past the closing tag.
+func rawBlockEnd(text string, i int) (int, bool) {
+	start := i + len(rawOpen)
+	j := indexRawClose(text[start:])
+	if j < 0 {
+		return 0, false
+	}
+	return start + j + len(rawClose), true
+}
+
+// indexRawClose returns the position of the first (/raw) tag in text, or -1.
+func indexRawClose(text string) int {
+	for j := 0; j+len(rawClose) <= len(text); j++ {
+		if text[j] == '(' && hasPrefixFold(text[j:], rawClose) {
+			return j
+		}
+	}
+	return -1
+}
+
+// unclosedRaw returns the position of a (raw) tag in text that is not
+// closed, or -1.
+func unclosedRaw(text string) int {
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && strings.HasPrefix(text[i+1:], "("):
+			i++
+		case hasPrefixFold(text[i:], rawOpen):
+			end, ok := rawBlockEnd(text, i)
+			if !ok {
+				return i
+			}
+			i = end - 1
+		}
+	}
+	return -1
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// contractionSuffixes are the endings after which an apostrophe belongs to
+// the word rather than opening a quote.
+var contractionSuffixes = []string{"s", "t", "m", "d", "re", "ve", "ll"}
+
+// scanWord returns the end of the word starting at i. An apostrophe followed
+// by a contraction ending stays inside the word (don't, I'm), and so does a
+// '.' or ',' between two digits (3.14, 1,000) or a '.' between a hex number
+// and a digit or hex fraction (1A.8, 0xff.ff). A hyphen between two letters
+// joins a compound word (well-known), and a leading '-' is the word's sign.
+func scanWord(text string, i int) int {
+	start := i
+	if isSign(text, i) {
+		i++
+	}
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if isWordRune(r) {
+			i += size
+			continue
+		}
+
+		if i+size < len(text) && i > 0 {
+			prev, _ := utf8.DecodeLastRuneInString(text[:i])
+			next, _ := utf8.DecodeRuneInString(text[i+size:])
+			if r == '\'' && unicode.IsLetter(prev) && isContraction(text[i+size:]) {
+				i += size
+				continue
+			}
+			if (r == '.' || r == ',') && unicode.IsDigit(prev) && unicode.IsDigit(next) {
+				i += size
+				continue
+			}
+			if r == '-' && unicode.IsLetter(prev) && unicode.IsLetter(next) {
+				i += size
+				continue
+			}
+			if r == '.' && isHexLiteral(text[start:i]) && (unicode.IsDigit(next) || isHexFraction(text[start:i], text[i+size:])) {
+				i += size
+				continue
+			}
+		}
+		break
+	}
+	return i
+}
+
+// scanMarker tries to read a marker such as "(up)", "( cap , 3 )" or the
+// nested form "(cap(low))" starting at the '(' at position i, and returns
+// it as a marker token. A nested marker is flattened to its outer command.
+// A '>' just before or after the name, as in "(>cap)" or "(up>, 2)", makes
+// the marker apply to the words after it, and a ":begin" or ":end" suffix
+// makes it one end of a block. Names joined by '|' or '+', as in
+// "(low+cap, 3)", form a chain.
+func scanMarker(text string, i int, registry *Registry) (token, bool) {
+	start := i
+	i = skipBlanks(text, i+1)
+
+	forward := false
+	if i < len(text) && text[i] == '>' {
+		forward = true
+		i = skipBlanks(text, i+1)
+	}
+
+	var names []string
+	for {
+		nameStart := i
+		for i < len(text) && (isASCIILetter(text[i]) || (i > nameStart && isNameChar(text[i]))) {
+			i++
+		}
+		if i == nameStart {
+			return token{}, false
+		}
+		names = append(names, strings.ToLower(text[nameStart:i]))
+
+		j := skipBlanks(text, i)
+		if j >= len(text) || (text[j] != '|' && text[j] != '+') {
+			break
+		}
+		i = skipBlanks(text, j+1)
+	}
+	name := strings.Join(names, chainSeparator)
+
+	block := ""
+	if i < len(text) && text[i] == ':' {
+		j := i + 1
+		for j < len(text) && isASCIILetter(text[j]) {
+			j++
+		}
+		block = strings.ToLower(text[i+1 : j])
+		if (block != blockBegin && block != blockEnd) || forward {
+			return token{}, false
+		}
+		i = j
+	}
+	i = skipBlanks(text, i)
+
+	if i < len(text) && text[i] == '>' && !forward && block == "" {
+		forward = true
+		i = skipBlanks(text, i+1)
+	}
+
+	for i < len(text) && text[i] == '(' && block == "" {
+		nested, nestedOK := scanMarker(text, i, registry)
+		if !nestedOK {
+			return token{}, false
+		}
+		i = skipBlanks(text, nested.pos+len(nested.text))
+	}
+
+	var args []string
+	for i < len(text) && text[i] == ',' {
+		i = skipBlanks(text, i+1)
+		argStart := i
+		for i < len(text) && !strings.ContainsRune(",()\n", rune(text[i])) {
+			i++
+		}
+		args = append(args, strings.TrimSpace(text[argStart:i]))
+	}
+
+	if i >= len(text) || text[i] != ')' {
+		return token{}, false
+	}
+
+	// A block takes no count, and its closing tag no arguments at all.
+	marker, ok := registry.resolve(name)
+	switch {
+	case !ok:
+		return token{}, false
+	case block == blockEnd:
+		if len(args) > 0 {
+			return token{}, false
+		}
+	case !registry.accepts(name, args):
+		return token{}, false
+	case block == blockBegin && countArg(marker) >= 0 && countArg(marker) < len(args):
+		return token{}, false
+	}
+
+	i++
+	return token{kind: tokMarker, text: text[start:i], pos: start, name: name, args: args, forward: forward, block: block}, true
+}
+
+// diagnoseMarker explains why the '(' at i did not start a marker, when it
+// looks like one was meant: a registered name followed by ',' or ')', or by
+// the end of the line, or a chain with a name that is not registered.
+// Ordinary parentheses such as "(cap in hand)" are not reported.
+func diagnoseMarker(text string, i int, registry *Registry, diags *diagnostics) {
+	j := skipBlanks(text, i+1)
+	if j < len(text) && text[j] == '>' {
+		j = skipBlanks(text, j+1)
+	}
+	var names []string
+	for {
+		nameStart := j
+		for j < len(text) && (isASCIILetter(text[j]) || (j > nameStart && isNameChar(text[j]))) {
+			j++
+		}
+		if j == nameStart {
+			return
+		}
+		names = append(names, strings.ToLower(text[nameStart:j]))
+
+		k := skipBlanks(text, j)
+		if k >= len(text) || (text[k] != '|' && text[k] != '+') {
+			break
+		}
+		j = skipBlanks(text, k+1)
+	}
+	if _, ok := registry.Lookup(names[0]); !ok {
+		return
+	}
+	for _, name := range names[1:] {
+		if _, ok := registry.Lookup(name); !ok {
+			diags.add(SeverityWarning, CodeUnknownMarker, i, "unknown marker %q in chain %s", name, strings.Join(names, chainSeparator))
+			return
+		}
+	}
+	marker, _ := registry.resolve(strings.Join(names, chainSeparator))
+	if j < len(text) && text[j] == ':' {
+		for j++; j < len(text) && isASCIILetter(text[j]); j++ {
+		}
+	}
+
+	j = skipBlanks(text, j)
+	if j < len(text) && text[j] == '>' {
+		j = skipBlanks(text, j+1)
+	}
+	if j < len(text) && text[j] != ',' && text[j] != ')' && text[j] != '\n' {
+		return
+	}
+
+	line := text[i:]
+	if end := strings.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	closing := strings.IndexByte(line, ')')
+	if closing < 0 {
+		diags.add(SeverityWarning, CodeUnclosedMarker, i, "marker %q is missing a closing parenthesis", strings.TrimSpace(line))
+		return
+	}
+	// A marker in the arguments runs first and the parser reads the
+	// result, so "(cap, a (hex) (bin))" is left for it to report.
+	if inner := strings.IndexByte(line[1:closing], '('); inner >= 0 {
+		if _, ok := scanMarker(text, i+1+inner, registry); ok {
+			return
+		}
+	}
+	diags.add(SeverityWarning, CodeBadArguments, i, "bad arguments in %s, expected %s", line[:closing+1], usage(marker))
+}
+
+func isContraction(rest string) bool {
+	end := 0
+	for end < len(rest) && isASCIILetter(rest[end]) {
+		end++
+	}
+	if end < len(rest) {
+		if r, _ := utf8.DecodeRuneInString(rest[end:]); isWordRune(r) {
+			return false
+		}
+	}
+
+	suffix := strings.ToLower(rest[:end])
+	for _, s := range contractionSuffixes {
+		if suffix == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isHexLiteral reports whether s is a hex number, possibly signed,
+// prefixed with 0x or split by underscores.
+func isHexLiteral(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if len(s) > 2 && strings.EqualFold(s[:2], "0x") {
+		s = s[2:]
+	}
+	return isDigits(strings.ReplaceAll(s, "_", ""), 16)
+}
+
+// isHexFraction reports whether the word at the start of s is made of hex
+// digits, so that a '.' before it continues the hex literal integer as in
+// "1A.F". Letters alone are as likely to be two words run together, as in
+// "bad.Add", so the literal needs a decimal digit or a 0x prefix.
+func isHexFraction(integer, s string) bool {
+	end := strings.IndexFunc(s, func(r rune) bool { return !isWordRune(r) })
+	if end < 0 {
+		end = len(s)
+	}
+	if !isDigits(strings.ReplaceAll(s[:end], "_", ""), 16) {
+		return false
+	}
+	integer = strings.TrimPrefix(integer, "-")
+	return hasPrefixFold(integer, "0x") || strings.ContainsAny(integer+s[:end], "0123456789")
+}
+
+// isSign reports whether the '-' at i is the sign of the word that follows
+// it, as in "-FF", rather than a dash or a hyphen inside a word.
+func isSign(text string, i int) bool {
+	if text[i] != '-' || i+1 >= len(text) {
+		return false
+	}
+	next, _ := utf8.DecodeRuneInString(text[i+1:])
+	if !isWordRune(next) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	prev, _ := utf8.DecodeLastRuneInString(text[:i])
+	return !isWordRune(prev)
+}
+
+func skipBlanks(text string, i int) int {
+	for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isASCIILetter(c) || (c >= '0' && c <= '9') || c == '_'
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
@@ -0,0 +1,107 @@
+package processor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// describeTokens renders tokens as "kind:text" for compact comparisons.
+// Whitespace tokens are left out.
+func describeTokens(tokens []token) []string {
+	var out []string
+	for _, tok := range tokens {
+		if tok.kind == tokSpace {
+			continue
+		}
+		out = append(out, tok.kind.String()+":"+tok.text)
+	}
+	return out
+}
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"words and punctuation", "Hello, world!", []string{"word:Hello", "punctuation:,", "word:world", "punctuation:!"}},
+		{"grouped punctuation", "wait...?!", []string{"word:wait", "punctuation:...?!"}},
+		{"numbers", "42 3.14 1,000", []string{"number:42", "number:3.14", "number:1,000"}},
+		{"comma after number", "42, then", []string{"number:42", "punctuation:,", "word:then"}},
+		{"hex fractions", "1A.8 1A.F 0xff.ff", []string{"word:1A.8", "word:1A.F", "word:0xff.ff"}},
+		{"not a hex fraction", "1A.G end.Be", []string{"word:1A", "punctuation:.", "word:G", "word:end", "punctuation:.", "word:Be"}},
+		{"hex words", "bad.Add face.Be", []string{"word:bad", "punctuation:.", "word:Add", "word:face", "punctuation:.", "word:Be"}},
+		{"contractions", "don't I'm they're", []string{"word:don't", "word:I'm", "word:they're"}},
+		{"quote is not a contraction", "'tis 'quoted'", []string{"quote:'", "word:tis", "quote:'", "word:quoted", "quote:'"}},
+		{"newline", "a\nb", []string{"word:a", "newline:\n", "word:b"}},
+		{"marker", "it (up)", []string{"word:it", "marker:(up)"}},
+		{"marker with count", "it ( cap , 3 )", []string{"word:it", "marker:( cap , 3 )"}},
+		{"nested marker", "it (cap(low))", []string{"word:it", "marker:(cap(low))"}},
+		{"glued marker", "it(up)", []string{"word:it", "marker:(up)"}},
+		{"ordinary parentheses", "(cap in hand)", []string{"symbol:(", "word:cap", "word:in", "word:hand", "symbol:)"}},
+		{"unknown name", "(foo)", []string{"symbol:(", "word:foo", "symbol:)"}},
+		{"bad count", "it (up, x)", []string{"word:it", "symbol:(", "word:up", "punctuation:,", "word:x", "symbol:)"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describeTokens(lex(tt.in, defaultConfig(), &diagnostics{}))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lex(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLexMarkerFields(t *testing.T) {
+	tokens := lex("x (CAP, 3)", defaultConfig(), &diagnostics{})
+	m := tokens[len(tokens)-1]
+	if m.kind != tokMarker || m.name != "cap" || !reflect.DeepEqual(m.args, []string{"3"}) || m.pos != 2 {
+		t.Errorf("got %+v, want marker cap with args [3] at 2", m)
+	}
+}
+
+func TestLexPositions(t *testing.T) {
+	text := "one  two\nthree"
+	for _, tok := range lex(text, defaultConfig(), &diagnostics{}) {
+		if !strings.HasPrefix(text[tok.pos:], tok.text) {
+			t.Errorf("token %q at %d does not match the input", tok.text, tok.pos)
+		}
+	}
+}
+
+func TestLexWithoutMarkers(t *testing.T) {
+	cfg := defaultConfig()
+	WithoutRules(RuleMarkers)(cfg)
+	got := describeTokens(lex("it (up)", cfg, &diagnostics{}))
+	want := []string{"word:it", "symbol:(", "word:up", "symbol:)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEscapes(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{`use \(up) here`, "use (up) here"},
+		{`word\(up)`, "word(up)"},
+		{`\(cap, 2) stays`, "(cap, 2) stays"},
+		{`\(up>) next`, "(up>) next"},
+		{`a \x stays`, `a \x stays`},
+		{"x (raw)keep (up) ,this(/raw) y (up)", "x keep (up) ,this Y"},
+		{"(raw)it (up) , 1E (hex)(/raw)", "it (up) , 1E (hex)"},
+		{"one\n(raw)\ntwo  (hex) ,\n(/raw)\nthree (up)", "one\n\ntwo  (hex) ,\n\nTHREE"},
+	})
+}
+
+func TestUnmatchedRaw(t *testing.T) {
+	for _, in := range []string{"(raw) open (up)", "close (/raw)"} {
+		r := Run(in)
+		if len(r.Diagnostics) == 0 || r.Diagnostics[0].Code != CodeUnmatchedBlock {
+			t.Errorf("Run(%q) diagnostics = %v, want %s", in, r.Diagnostics, CodeUnmatchedBlock)
+		}
+	}
+	if got, want := ProcessText("(raw) open (up)"), "(raw) OPEN"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,318 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Scope describes which words before a marker it applies to, or after it
+// for a forward marker such as "(>cap)".
+type Scope int
+
+const (
+	// ScopeWord targets the single word before the marker.
+	ScopeWord Scope = iota
+
+	// ScopeGroup targets the word before the marker, or the whole quoted or
+	// parenthesized group when the marker directly follows one.
+	ScopeGroup
+
+	// ScopeText is ScopeGroup for markers that make no sense on numbers:
+	// when the target is a number, the nearest non-numeric word on the line
+	// in the marker's direction is used instead.
+	ScopeText
+
+	// ScopeExpression targets the arithmetic before the marker: the numbers,
+	// operators and parenthesized groups up to the first other word on the
+	// line. Apply gets every token of it, operators and parentheses
+	// included, and the whole expression is replaced.
+	ScopeExpression
+)
+
+// ArgKind is the type of a marker argument.
+type ArgKind int
+
+const (
+	// ArgCount is a word count, as in "(up, 3)". It replaces the marker's
+	// scope with the N words before it on the same line, or after it for a
+	// forward marker.
+	ArgCount ArgKind = iota
+
+	// ArgInt is any other integer argument.
+	ArgInt
+
+	// ArgText is a free-form argument.
+	ArgText
+)
+
+// Arg describes one positional argument of a marker.
+type Arg struct {
+	Name     string
+	Kind     ArgKind
+	Optional bool
+}
+
+// Call carries what a marker needs to know while it is applied.
+type Call struct {
+	// Args holds the marker's arguments as written, count included.
+	Args []string
+
+	// Count is the value of the ArgCount argument, or 0 when absent.
+	Count int
+
+	// Locale is the processor's language tag, e.g. "en" or "tr".
+	Locale string
+
+	caseRules unicode.SpecialCase
+	thousands string
+	precision int
+}
+
+// Upper converts s to upper case using the processor's locale.
+func (c *Call) Upper(s string) string {
+	return strings.ToUpperSpecial(c.caseRules, s)
+}
+
+// Lower converts s to lower case using the processor's locale.
+func (c *Call) Lower(s string) string {
+	return strings.ToLowerSpecial(c.caseRules, s)
+}
+
+// Capitalize upper-cases the first letter of s and lower-cases the rest.
+func (c *Call) Capitalize(s string) string {
+	return capitalize(s, c.caseRules)
+}
+
+// Marker is a "(name)" or "(name, args...)" command that rewrites the
+// words it targets.
+type Marker interface {
+	// Name is the command as written inside the parentheses, in lower case.
+	Name() string
+
+	// Args lists the positional arguments that may follow the name.
+	Args() []Arg
+
+	// Scope says which words the marker applies to when no count is given.
+	Scope() Scope
+
+	// Apply returns the replacement for the target words, normally one per
+	// word. Returning fewer words merges the targets: the replacements go
+	// to the last targets and the leading words are removed. Returning more
+	// keeps the extra words with the last target. An error leaves the words
+	// unchanged.
+	Apply(call *Call, words []string) ([]string, error)
+}
+
+// NewMarker builds a Marker from its parts.
+func NewMarker(name string, scope Scope, args []Arg, apply func(call *Call, words []string) ([]string, error)) Marker {
+	return &funcMarker{name: strings.ToLower(name), scope: scope, args: args, apply: apply}
+}
+
+type funcMarker struct {
+	name  string
+	scope Scope
+	args  []Arg
+	apply func(call *Call, words []string) ([]string, error)
+}
+
+func (m *funcMarker) Name() string { return m.name }
+func (m *funcMarker) Args() []Arg  { return m.args }
+func (m *funcMarker) Scope() Scope { return m.scope }
+
+func (m *funcMarker) Apply(call *Call, words []string) ([]string, error) {
+	return m.apply(call, words)
+}
+
+var markerNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Registry holds the markers a Processor recognizes. Register markers
+// before passing the registry to New; New keeps its own copy.
+type Registry struct {
+	markers map[string]Marker
+}
+
+// NewRegistry returns a registry holding the given markers.
+func NewRegistry(markers ...Marker) (*Registry, error) {
+	r := &Registry{markers: map[string]Marker{}}
+	for _, m := range markers {
+		if err := r.Register(m); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// DefaultRegistry returns a new registry with the built-in markers.
+func DefaultRegistry() *Registry {
+	r := &Registry{markers: map[string]Marker{}}
+	for _, m := range builtinMarkers() {
+		r.markers[m.Name()] = m
+	}
+	return r
+}
+
+// Register adds m to the registry. Names are matched case-insensitively and
+// must start with a letter followed by letters, digits or underscores.
+func (r *Registry) Register(m Marker) error {
+	name := strings.ToLower(m.Name())
+	if !markerNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid marker name %q", m.Name())
+	}
+	if _, exists := r.markers[name]; exists {
+		return fmt.Errorf("marker %q is already registered", name)
+	}
+	r.markers[name] = m
+	return nil
+}
+
+// Replace adds m to the registry, replacing any marker with the same name.
+func (r *Registry) Replace(m Marker) error {
+	delete(r.markers, strings.ToLower(m.Name()))
+	return r.Register(m)
+}
+
+// Lookup returns the marker registered under name.
+func (r *Registry) Lookup(name string) (Marker, bool) {
+	m, ok := r.markers[strings.ToLower(name)]
+	return m, ok
+}
+
+// Names returns the registered marker names in sorted order.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.markers))
+	for name := range r.markers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Registry) clone() *Registry {
+	c := &Registry{markers: make(map[string]Marker, len(r.markers))}
+	for name, m := range r.markers {
+		c.markers[name] = m
+	}
+	return c
+}
+
+// usage describes how a marker is written, e.g. "(up[, count])".
+func usage(m Marker) string {
+	var b strings.Builder
+	b.WriteString("(" + m.Name())
+	for _, arg := range m.Args() {
+		if arg.Optional {
+			b.WriteString("[, " + arg.Name + "]")
+		} else {
+			b.WriteString(", " + arg.Name)
+		}
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+var intArgRegex = regexp.MustCompile(`^-?\d+$`)
+
+// accepts reports whether args fit the schema of the marker called name.
+// For a chain, they must fit every marker in it.
+func (r *Registry) accepts(name string, args []string) bool {
+	m, ok := r.resolve(name)
+	if !ok {
+		return false
+	}
+	if c, ok := m.(*chainMarker); ok {
+		for _, step := range c.steps {
+			if !fits(step.Args(), args) {
+				return false
+			}
+		}
+		return true
+	}
+	return fits(m.Args(), args)
+}
+
+func fits(schema []Arg, args []string) bool {
+	if len(args) > len(schema) {
+		return false
+	}
+	for i, arg := range schema {
+		if i >= len(args) {
+			if !arg.Optional {
+				return false
+			}
+			continue
+		}
+		if arg.Kind != ArgText && !intArgRegex.MatchString(args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// chainSeparator joins the names of a chained marker such as "(low+cap)".
+// "(hex|cap)" is read the same way.
+const chainSeparator = "+"
+
+// resolve looks up name, which may also be a chain of registered names
+// such as "low+cap".
+func (r *Registry) resolve(name string) (Marker, bool) {
+	if !strings.Contains(name, chainSeparator) {
+		return r.Lookup(name)
+	}
+
+	var steps []Marker
+	for _, part := range strings.Split(name, chainSeparator) {
+		m, ok := r.Lookup(part)
+		if !ok {
+			return nil, false
+		}
+		steps = append(steps, m)
+	}
+	return &chainMarker{name: name, steps: steps}, true
+}
+
+// chainMarker applies several markers to the same words, left to right.
+// Every step gets the same arguments, and the chain targets the narrowest
+// scope of its steps, or an expression when the first step reads one.
+type chainMarker struct {
+	name  string
+	steps []Marker
+}
+
+func (c *chainMarker) Name() string { return c.name }
+func (c *chainMarker) Args() []Arg  { return c.steps[0].Args() }
+
+func (c *chainMarker) Scope() Scope {
+	if c.steps[0].Scope() == ScopeExpression {
+		return ScopeExpression
+	}
+	scope := ScopeText
+	for _, step := range c.steps {
+		scope = min(scope, step.Scope())
+	}
+	return scope
+}
+
+func (c *chainMarker) Apply(call *Call, words []string) ([]string, error) {
+	for _, step := range c.steps {
+		var err error
+		words, err = step.Apply(call, words)
+		if err != nil {
+			return nil, fmt.Errorf("(%s): %w", step.Name(), err)
+		}
+	}
+	return words, nil
+}
+
+// countArg returns the position of the ArgCount argument in the schema of
+// m, or -1.
+func countArg(m Marker) int {
+	for i, arg := range m.Args() {
+		if arg.Kind == ArgCount {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var redact = NewMarker("redact", ScopeGroup, countArgs, func(call *Call, words []string) ([]string, error) {
+	out := make([]string, len(words))
+	for i, w := range words {
+		out[i] = strings.Repeat("*", len(w))
+	}
+	return out, nil
+})
+
+func TestRegister(t *testing.T) {
+	r, err := NewRegistry(redact)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Register(redact); err == nil {
+		t.Error("registering a name twice succeeded")
+	}
+	for _, name := range []string{"", "1st", "no-dash", "has space"} {
+		m := NewMarker(name, ScopeWord, nil, nil)
+		if err := r.Register(m); err == nil {
+			t.Errorf("Register accepted the name %q", name)
+		}
+	}
+	if err := r.Replace(NewMarker("REDACT", ScopeWord, nil, nil)); err != nil {
+		t.Errorf("Replace: %v", err)
+	}
+	if m, ok := r.Lookup("Redact"); !ok || m.Scope() != ScopeWord {
+		t.Error("Lookup did not find the replaced marker")
+	}
+}
+
+func TestRegistryNames(t *testing.T) {
+	r, _ := NewRegistry(NewMarker("zeta", ScopeWord, nil, nil), NewMarker("alpha", ScopeWord, nil, nil))
+	if got, want := r.Names(), []string{"alpha", "zeta"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCustomMarkers(t *testing.T) {
+	repeat := NewMarker("repeat", ScopeWord, []Arg{{Name: "times", Kind: ArgInt}}, func(call *Call, words []string) ([]string, error) {
+		n := 0
+		for _, c := range call.Args[0] {
+			n = n*10 + int(c-'0')
+		}
+		return []string{strings.Repeat(words[0], n)}, nil
+	})
+	fail := NewMarker("fail", ScopeWord, nil, func(call *Call, words []string) ([]string, error) {
+		return nil, errors.New("always fails")
+	})
+
+	p := New(WithMarkers(redact, repeat, fail))
+	tests := []struct{ in, want string }{
+		{"my name is bob smith (redact, 2)", "my name is *** *****"},
+		{"say 'top secret' (redact)", "say '*** ******'"},
+		{"ha (repeat, 3)", "hahaha"},
+		{"ha (repeat)", "ha (repeat)"},
+		{"ha (repeat, x)", "ha (repeat, x)"},
+		{"word (fail)", "word"},
+		{"still (up) works", "STILL works"},
+	}
+	for _, tt := range tests {
+		if got := p.Process(tt.in); got != tt.want {
+			t.Errorf("Process(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMarkerReturningEmptyWord(t *testing.T) {
+	erase := NewMarker("erase", ScopeGroup, countArgs, func(call *Call, words []string) ([]string, error) {
+		return make([]string, len(words)), nil
+	})
+
+	p := New(WithMarkers(erase))
+	tests := []struct{ in, want string }{
+		{"a secret (erase) thing", "a thing"},
+		{"a secret (erase) apple", "an apple"},
+		{"keep two words (erase, 2) here", "keep here"},
+		{"a 'top secret' (erase)", "a ''"},
+	}
+	for _, tt := range tests {
+		if got := p.Process(tt.in); got != tt.want {
+			t.Errorf("Process(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMarkerReturningNoWords(t *testing.T) {
+	drop := NewMarker("drop", ScopeGroup, countArgs, func(call *Call, words []string) ([]string, error) {
+		return nil, nil
+	})
+
+	p := New(WithMarkers(drop))
+	tests := []struct{ in, want string }{
+		{"secret (drop) thing", "thing"},
+		{"a secret (drop) apple", "an apple"},
+		{"keep two words (drop, 2) here", "keep here"},
+		{"keep two, words (drop, 2) here", "keep two, words here"},
+	}
+	for _, tt := range tests {
+		if got := p.Process(tt.in); got != tt.want {
+			t.Errorf("Process(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWithRegistry(t *testing.T) {
+	r, _ := NewRegistry(redact)
+	p := New(WithRegistry(r))
+	if got, want := p.Process("abc (redact) abc (up)"), "*** abc (up)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// New keeps its own copy of the registry.
+	r.Register(NewMarker("up", ScopeWord, nil, caseMarker("up")))
+	if got, want := p.Process("abc (up)"), "abc (up)"; got != want {
+		t.Errorf("after changing the registry got %q, want %q", got, want)
+	}
+}
+
+func TestWithMarkersReplacesBuiltins(t *testing.T) {
+	shout := NewMarker("up", ScopeWord, nil, func(call *Call, words []string) ([]string, error) {
+		return []string{strings.ToUpper(words[0]) + "!"}, nil
+	})
+	if got, want := New(WithMarkers(shout)).Process("hey (up)"), "HEY!"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := New().Process("hey (up)"), "HEY"; got != want {
+		t.Errorf("the default registry changed: got %q, want %q", got, want)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	m := NewMarker("pad", ScopeWord, []Arg{{Name: "width", Kind: ArgInt}, {Name: "fill", Kind: ArgText, Optional: true}}, nil)
+	if got, want := usage(m), "(pad, width[, fill])"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFits(t *testing.T) {
+	schema := []Arg{{Name: "n", Kind: ArgInt}, {Name: "label", Kind: ArgText, Optional: true}}
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"3"}, true},
+		{[]string{"-3", "anything"}, true},
+		{nil, false},
+		{[]string{"x"}, false},
+		{[]string{"1", "2", "3"}, false},
+	}
+	for _, tt := range tests {
+		if got := fits(schema, tt.args); got != tt.want {
+			t.Errorf("fits(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Rule names one of the processing stages that can be switched on or off.
+type Rule string
+
+const (
+	RuleMarkers     Rule = "markers"
+	RuleSpaces      Rule = "spaces"
+	RuleQuotes      Rule = "quotes"
+	RulePunctuation Rule = "punctuation"
+	RuleArticles    Rule = "articles"
+)
+
+// AllRules lists every rule in the order the processor applies them.
+var AllRules = []Rule{RuleMarkers, RuleSpaces, RuleQuotes, RulePunctuation, RuleArticles}
+
+// ArticleRule reports whether the indefinite article before word should be
+// "an" rather than "a".
+type ArticleRule func(word string) bool
+
+// Option configures a Processor.
+type Option func(*config)
+
+type config struct {
+	rules     map[Rule]bool
+	locale    string
+	caseRules unicode.SpecialCase
+	strict    bool
+	maxCount  int
+	quotes    string
+	needsAn   ArticleRule
+	registry  *Registry
+	trace     bool
+	thousands string
+	precision int
+}
+
+func defaultConfig() *config {
+	cfg := &config{
+		rules:     map[Rule]bool{},
+		locale:    "en",
+		quotes:    `'"`,
+		precision: 10,
+		needsAn:   englishNeedsAn,
+		registry:  DefaultRegistry(),
+	}
+	for _, r := range AllRules {
+		cfg.rules[r] = true
+	}
+	return cfg
+}
+
+func (c *config) enabled(r Rule) bool {
+	return c.rules[r]
+}
+
+func (c *config) isQuote(r rune) bool {
+	return strings.ContainsRune(c.quotes, r)
+}
+
+// WithRules enables exactly the given rules and disables all others.
+func WithRules(rules ...Rule) Option {
+	return func(c *config) {
+		c.rules = map[Rule]bool{}
+		for _, r := range rules {
+			c.rules[r] = true
+		}
+	}
+}
+
+// WithoutRules disables the given rules.
+func WithoutRules(rules ...Rule) Option {
+	return func(c *config) {
+		for _, r := range rules {
+			delete(c.rules, r)
+		}
+	}
+}
+
+// WithLocale sets the language used for case conversion, as a BCP 47 tag
+// such as "en" or "tr-TR". Turkish and Azeri get their dotted and dotless i
+// rules. The a/an rule only runs for English.
+func WithLocale(locale string) Option {
+	return func(c *config) {
+		lang := strings.ToLower(locale)
+		if i := strings.IndexAny(lang, "-_"); i >= 0 {
+			lang = lang[:i]
+		}
+
+		c.locale = lang
+		c.caseRules = nil
+		if lang == "tr" || lang == "az" {
+			c.caseRules = unicode.TurkishCase
+		}
+	}
+}
+
+// WithStrict stops the processor from guessing: a case marker after a number
+// is not moved to an earlier word, a (hex) or (bin) marker whose word is not
+// a valid number is left in the text, and every diagnostic is reported as
+// an error instead of a warning.
+func WithStrict(strict bool) Option {
+	return func(c *config) {
+		c.strict = strict
+	}
+}
+
+// WithTrace makes Run fill in Result.Trace with every marker step and the
+// text after every stage.
+func WithTrace(trace bool) Option {
+	return func(c *config) {
+		c.trace = trace
+	}
+}
+
+// WithMaxCount caps the N of "(up, N)" and friends. Zero means no limit.
+func WithMaxCount(n int) Option {
+	return func(c *config) {
+		c.maxCount = n
+	}
+}
+
+// WithThousandsSeparator groups the digits of decimal numbers produced by
+// the number markers, so "FFFFFF (hex)" gives "16,777,215" with ",". An
+// empty separator, the default, turns grouping off.
+func WithThousandsSeparator(sep string) Option {
+	return func(c *config) {
+		c.thousands = sep
+	}
+}
+
+// WithPrecision sets how many digits after the point the number markers
+// write when a fraction does not end sooner, as 0.1 does not in binary.
+// The default is 10.
+func WithPrecision(digits int) Option {
+	return func(c *config) {
+		c.precision = max(digits, 0)
+	}
+}
+
+// WithQuotes sets the characters treated as quotes. Each character is its
+// own opening and closing mark. The default is ' and ".
+func WithQuotes(quotes ...rune) Option {
+	return func(c *config) {
+		c.quotes = string(quotes)
+	}
+}
+
+// WithArticleRule replaces the test that decides between "a" and "an".
+func WithArticleRule(rule ArticleRule) Option {
+	return func(c *config) {
+		c.needsAn = rule
+	}
+}
+
+// WithRegistry makes the processor recognize exactly the markers in r.
+// Start from DefaultRegistry to keep the built-in ones.
+func WithRegistry(r *Registry) Option {
+	return func(c *config) {
+		c.registry = r.clone()
+	}
+}
+
+// WithMarkers adds markers to the processor, replacing built-in markers of
+// the same name. Markers with invalid names are ignored; use
+// Registry.Register to find out why a marker is rejected.
+func WithMarkers(markers ...Marker) Option {
+	return func(c *config) {
+		for _, m := range markers {
+			c.registry.Replace(m)
+		}
+	}
+}
+
+func englishNeedsAn(word string) bool {
+	return word != "" && strings.ContainsRune("aeiouh", unicode.ToLower([]rune(word)[0]))
+}
@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		in   string
+		want string
+	}{
+		{"defaults", nil, "hello (up) ,a apple", "HELLO, an apple"},
+		{"only markers", []Option{WithRules(RuleMarkers)}, "hello (up) ,a apple", "HELLO ,a apple"},
+		{"without markers", []Option{WithoutRules(RuleMarkers)}, "hello (up) ,a apple", "hello (up), an apple"},
+		{"without articles", []Option{WithoutRules(RuleArticles)}, "a apple", "a apple"},
+		{"turkish", []Option{WithLocale("tr-TR")}, "istanbul (up) IŞIK (low)", "İSTANBUL ışık"},
+		{"english dotted i", []Option{WithLocale("en")}, "istanbul (up)", "ISTANBUL"},
+		{"articles only in english", []Option{WithLocale("fr")}, "a apple", "a apple"},
+		{"max count", []Option{WithMaxCount(2)}, "one two three (up, 3)", "one TWO THREE"},
+		{"quotes", []Option{WithQuotes('"')}, `' hi ' " hi "`, `' hi ' "hi"`},
+		{"article rule", []Option{WithArticleRule(func(word string) bool { return strings.HasPrefix(word, "u") })}, "a unicorn a apple", "an unicorn a apple"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := New(tt.opts...).Process(tt.in); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxCountIsReported(t *testing.T) {
+	r := New(WithMaxCount(2)).Run("one two three (up, 3)")
+	if len(r.Diagnostics) != 1 || r.Diagnostics[0].Code != CodeBadCount {
+		t.Errorf("got %v, want one %s diagnostic", r.Diagnostics, CodeBadCount)
+	}
+}
+
+func TestProcessorIsSafeForConcurrentUse(t *testing.T) {
+	p := New(WithLocale("tr"))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if got := p.Process("istanbul (up) a apple"); got != "İSTANBUL a apple" {
+					t.Errorf("got %q", got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
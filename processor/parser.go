@@ -0,0 +1,518 @@
+package processor
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// span is a half-open range of token indices [start, end).
+type span struct {
+	start, end int
+}
+
+// markerNode is a marker in the document together with its parsed
+// arguments and the token spans it applies to.
+type markerNode struct {
+	marker Marker
+	name   string
+	text   string
+	args   []string
+	index  int
+	pos    int
+
+	// forward markers, written "(>cap)" or "(up>, 2)", apply to the words
+	// after them instead of before.
+	forward bool
+
+	// block markers, written "(up:begin) ... (up:end)", apply to every word
+	// up to their closing tag, across lines.
+	block bool
+
+	// count is the number of words requested with "(cmd, N)". Without a
+	// count the marker targets what its scope says.
+	count    int
+	hasCount bool
+
+	targets []span
+
+	// group is set for a marker whose arguments hold markers of their own,
+	// as in "(cap, a (hex) (bin))". Its name and arguments are only known
+	// once the markers inside have run, so it is read from the text then.
+	group *span
+
+	// keep leaves the marker text in the output instead of removing it.
+	keep bool
+}
+
+// document is the parsed form of the input: the token stream plus the
+// markers found in it, in source order.
+type document struct {
+	cfg     *config
+	text    string
+	tokens  []token
+	pairs   []int
+	markers []*markerNode
+	diags   diagnostics
+	edits   edits
+	trace   *Trace
+}
+
+func parse(text string, cfg *config) *document {
+	doc := &document{cfg: cfg, text: text}
+	doc.tokens = lex(text, cfg, &doc.diags)
+	doc.pairs = matchPairs(doc.tokens)
+	tokens := doc.tokens
+	open := map[string][]*markerNode{}
+
+	for i, tok := range tokens {
+		if o := doc.pairs[i]; o >= 0 && o < i && doc.isMarkerGroup(o, i) {
+			start, end := tokens[o].pos, tok.pos+len(tok.text)
+			doc.markers = append(doc.markers, &markerNode{text: text[start:end], index: o, pos: start, group: &span{o, i + 1}})
+			continue
+		}
+		if tok.kind != tokMarker {
+			continue
+		}
+
+		if tok.block == blockEnd {
+			stack := open[tok.name]
+			if len(stack) == 0 {
+				doc.diags.add(SeverityWarning, CodeUnmatchedBlock, tok.pos, "%s has no matching (%s:begin)", tok.text, tok.name)
+				continue
+			}
+			begin := stack[len(stack)-1]
+			open[tok.name] = stack[:len(stack)-1]
+			begin.targets = []span{{begin.index + 1, i}}
+			continue
+		}
+
+		marker, _ := cfg.registry.resolve(tok.name)
+		m := &markerNode{marker: marker, name: tok.name, text: tok.text, args: tok.args, index: i, pos: tok.pos, forward: tok.forward}
+
+		if tok.block == blockBegin {
+			m.block = true
+			open[tok.name] = append(open[tok.name], m)
+		} else {
+			doc.findTargets(m, i, i)
+		}
+
+		doc.markers = append(doc.markers, m)
+	}
+
+	unclosed := map[*markerNode]bool{}
+	for name, stack := range open {
+		for _, m := range stack {
+			doc.diags.add(SeverityWarning, CodeUnmatchedBlock, m.pos, "%s has no matching (%s:end)", m.text, name)
+			unclosed[m] = true
+		}
+	}
+	if len(unclosed) > 0 {
+		markers := doc.markers[:0]
+		for _, m := range doc.markers {
+			if !unclosed[m] {
+				markers = append(markers, m)
+			}
+		}
+		doc.markers = markers
+	}
+
+	return doc
+}
+
+// findTargets sets the targets of a marker that is not a block. Words
+// before it are looked for from token first, and words after it from
+// token last.
+func (d *document) findTargets(m *markerNode, first, last int) {
+	if c := countArg(m.marker); c >= 0 && c < len(m.args) {
+		m.count = parseCount(m.args[c])
+		m.hasCount = true
+		d.checkCount(m, m.args[c])
+		if m.count > 0 && m.forward {
+			m.targets = d.wordsAfter(last, m.count)
+		} else if m.count > 0 {
+			m.targets = d.wordsBefore(first, m.count)
+		}
+		if m.count > 0 && len(m.targets) > 0 && len(m.targets) < m.count {
+			verb := "precede"
+			if m.forward {
+				verb = "follow"
+			}
+			d.diags.add(SeverityWarning, CodeBadCount, m.pos, "%s asks for %s words but only %d %s it", m.text, m.args[c], len(m.targets), verb)
+		}
+	} else if m.forward {
+		if target, ok := d.targetAfter(last, m.marker.Scope()); ok {
+			m.targets = []span{target}
+		}
+	} else if target, ok := d.targetBefore(first, m.marker.Scope()); ok {
+		m.targets = []span{target}
+	}
+}
+
+// isMarkerGroup reports whether the parentheses at tokens open and close
+// hold a marker name, a comma and arguments with markers in them, as in
+// "(cap, a (hex) (bin))" or "(UP, (low) 1)".
+func (d *document) isMarkerGroup(open, close int) bool {
+	j := open + 1
+	for j < close && d.tokens[j].kind == tokSpace {
+		j++
+	}
+	if j >= close || d.tokens[j].kind != tokWord {
+		return false
+	}
+	if _, ok := d.cfg.registry.Lookup(d.tokens[j].text); !ok {
+		return false
+	}
+	for j++; j < close && d.tokens[j].kind == tokSpace; j++ {
+	}
+	if j >= close || d.tokens[j].text != "," {
+		return false
+	}
+	for ; j < close; j++ {
+		if d.tokens[j].kind == tokMarker {
+			return true
+		}
+	}
+	return false
+}
+
+// readGroup reads a marker group again once the markers inside it have
+// run, and fills in m from the marker it now spells. It reports whether
+// the group became a valid marker.
+func (d *document) readGroup(m *markerNode) bool {
+	var b strings.Builder
+	for j := m.group.start; j < m.group.end; j++ {
+		if d.tokens[j].kind != tokMarker {
+			b.WriteString(d.tokens[j].text)
+		}
+	}
+	text := b.String()
+
+	tok, ok := scanMarker(text, 0, d.cfg.registry)
+	if !ok || len(tok.text) != len(text) || tok.block != "" {
+		d.diags.add(SeverityWarning, CodeBadArguments, m.pos, "bad arguments in %s, which reads %s once the markers inside it ran", m.text, text)
+		return false
+	}
+
+	m.marker, _ = d.cfg.registry.resolve(tok.name)
+	m.name, m.args, m.forward = tok.name, tok.args, tok.forward
+	d.findTargets(m, m.group.start, m.group.end-1)
+	return true
+}
+
+// removeGroup turns the tokens of an applied marker group into marker
+// tokens, so that render drops the group like any other marker.
+func (d *document) removeGroup(m *markerNode) {
+	d.tokens[m.group.start] = token{kind: tokMarker, text: m.text, pos: m.pos, name: m.name}
+	for j := m.group.start + 1; j < m.group.end; j++ {
+		d.tokens[j] = token{kind: tokMarker, pos: d.tokens[j].pos}
+	}
+}
+
+// checkCount reports a count that is not positive or exceeds the
+// configured maximum, capping it in the latter case.
+func (d *document) checkCount(m *markerNode, arg string) {
+	switch {
+	case m.count <= 0:
+		d.diags.add(SeverityWarning, CodeBadCount, m.pos, "count %s in %s is not positive; marker ignored", arg, m.text)
+	case d.cfg.maxCount > 0 && m.count > d.cfg.maxCount:
+		d.diags.add(SeverityWarning, CodeBadCount, m.pos, "count %s in %s capped at %d", arg, m.text, d.cfg.maxCount)
+		m.count = d.cfg.maxCount
+	}
+}
+
+// parseCount reads the N of "(cmd, N)". Values that overflow an int are
+// clamped, so a huge count simply means "every word on the line".
+func parseCount(arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		if strings.HasPrefix(arg, "-") {
+			return math.MinInt
+		}
+		return math.MaxInt
+	}
+	return n
+}
+
+// matchPairs returns, for every quote and parenthesis token, the index of
+// its partner on the same line, or -1. Quotes of the same kind pair up in
+// order of appearance; parentheses nest.
+func matchPairs(tokens []token) []int {
+	pairs := make([]int, len(tokens))
+	for i := range pairs {
+		pairs[i] = -1
+	}
+
+	openQuote := map[string]int{}
+	var parens []int
+
+	for i, tok := range tokens {
+		switch {
+		case tok.kind == tokNewline:
+			openQuote = map[string]int{}
+			parens = parens[:0]
+		case tok.kind == tokQuote:
+			if j, ok := openQuote[tok.text]; ok {
+				pairs[i], pairs[j] = j, i
+				delete(openQuote, tok.text)
+			} else {
+				openQuote[tok.text] = i
+			}
+		case tok.kind == tokSymbol && tok.text == "(":
+			parens = append(parens, i)
+		case tok.kind == tokSymbol && tok.text == ")":
+			if len(parens) > 0 {
+				j := parens[len(parens)-1]
+				parens = parens[:len(parens)-1]
+				pairs[i], pairs[j] = j, i
+			}
+		}
+	}
+
+	return pairs
+}
+
+func (d *document) isClosing(i int) bool {
+	return d.pairs[i] >= 0 && d.pairs[i] < i
+}
+
+// targetBefore finds what a marker at index i without a count applies to:
+// the closest word on the same line or, unless scope is ScopeWord, a whole
+// quoted or parenthesized group when the marker directly follows one.
+// Whitespace, punctuation and other markers in between are skipped.
+func (d *document) targetBefore(i int, scope Scope) (span, bool) {
+	if scope == ScopeExpression {
+		return d.expression(i, -1)
+	}
+	for j := i - 1; j >= 0; j-- {
+		tok := d.tokens[j]
+		switch {
+		case tok.kind == tokNewline:
+			return span{}, false
+		case tok.kind == tokSpace || tok.kind == tokMarker || tok.kind == tokPunct:
+			continue
+		case tok.isWord():
+			return span{j, j + 1}, true
+		case scope != ScopeWord && d.isClosing(j):
+			group := span{d.pairs[j], j + 1}
+			if d.hasWords(group) {
+				return group, true
+			}
+			return span{}, false
+		default:
+			return span{}, false
+		}
+	}
+	return span{}, false
+}
+
+// targetAfter is targetBefore for forward markers: the closest word after
+// index i on the same line, or a whole quoted or parenthesized group the
+// marker directly precedes.
+func (d *document) targetAfter(i int, scope Scope) (span, bool) {
+	if scope == ScopeExpression {
+		return d.expression(i, 1)
+	}
+	for j := i + 1; j < len(d.tokens); j++ {
+		tok := d.tokens[j]
+		switch {
+		case tok.kind == tokNewline:
+			return span{}, false
+		case tok.kind == tokSpace || tok.kind == tokMarker || tok.kind == tokPunct:
+			continue
+		case tok.isWord():
+			return span{j, j + 1}, true
+		case scope != ScopeWord && d.pairs[j] > j:
+			group := span{j, d.pairs[j] + 1}
+			if d.hasWords(group) {
+				return group, true
+			}
+			return span{}, false
+		default:
+			return span{}, false
+		}
+	}
+	return span{}, false
+}
+
+// expression finds the arithmetic next to index i on the same line, before
+// it for step -1 and after it for step 1: numbers, operators and whole
+// parenthesized groups, up to another marker or any other token.
+func (d *document) expression(i, step int) (span, bool) {
+	first, last := -1, -1
+	hasNumber := false
+
+scan:
+	for j := i + step; j >= 0 && j < len(d.tokens); j += step {
+		tok := d.tokens[j]
+		end := j
+		switch {
+		case tok.kind == tokSpace:
+			continue
+		case tok.kind == tokNumber || tok.kind == tokWord && isDigits(tok.text[:1], 10):
+			hasNumber = true
+		case tok.kind == tokSymbol && len(tok.text) == 1 && strings.Contains("+-*/%^", tok.text):
+		case tok.kind == tokSymbol && d.pairs[j] >= 0 && d.isClosing(j) == (step < 0):
+			end = d.pairs[j]
+			hasNumber = hasNumber || d.hasWords(span{min(j, end), max(j, end) + 1})
+		default:
+			break scan
+		}
+
+		if first < 0 {
+			first, last = j, j
+		}
+		first, last = min(first, j, end), max(last, j, end)
+		j = end
+	}
+
+	if !hasNumber {
+		return span{}, false
+	}
+	return span{first, last + 1}, true
+}
+
+// wordsBefore collects up to count words preceding index i on the same
+// line, in source order.
+func (d *document) wordsBefore(i, count int) []span {
+	var spans []span
+	for j := i - 1; j >= 0 && len(spans) < count; j-- {
+		tok := d.tokens[j]
+		if tok.kind == tokNewline {
+			break
+		}
+		if tok.isWord() {
+			spans = append(spans, span{j, j + 1})
+		}
+	}
+
+	for l, r := 0, len(spans)-1; l < r; l, r = l+1, r-1 {
+		spans[l], spans[r] = spans[r], spans[l]
+	}
+	return spans
+}
+
+// wordsAfter collects up to count words following index i on the same
+// line.
+func (d *document) wordsAfter(i, count int) []span {
+	var spans []span
+	for j := i + 1; j < len(d.tokens) && len(spans) < count; j++ {
+		tok := d.tokens[j]
+		if tok.kind == tokNewline {
+			break
+		}
+		if tok.isWord() {
+			spans = append(spans, span{j, j + 1})
+		}
+	}
+	return spans
+}
+
+// lastTextWordBefore returns the last non-numeric word on the line before
+// index i.
+func (d *document) lastTextWordBefore(i int) (span, bool) {
+	for j := i - 1; j >= 0; j-- {
+		tok := d.tokens[j]
+		if tok.kind == tokNewline {
+			break
+		}
+		if tok.kind == tokWord {
+			return span{j, j + 1}, true
+		}
+	}
+	return span{}, false
+}
+
+// firstTextWordAfter returns the first non-numeric word on the line after
+// index i.
+func (d *document) firstTextWordAfter(i int) (span, bool) {
+	for j := i + 1; j < len(d.tokens); j++ {
+		tok := d.tokens[j]
+		if tok.kind == tokNewline {
+			break
+		}
+		if tok.kind == tokWord {
+			return span{j, j + 1}, true
+		}
+	}
+	return span{}, false
+}
+
+func (d *document) hasWords(s span) bool {
+	for j := s.start; j < s.end; j++ {
+		if d.tokens[j].isWord() {
+			return true
+		}
+	}
+	return false
+}
+
+// render returns the tokens with every marker removed, except the ones
+// flagged keep, which are turned back into plain text. A marker glued
+// between two words ("one(low)two") leaves a single space behind, and a
+// marker surrounded by whitespace takes one side of it along. Each removal
+// is recorded as an edit of the marker's rule.
+func (d *document) render() []token {
+	kept := map[int]bool{}
+	for _, m := range d.markers {
+		if m.keep {
+			kept[m.index] = true
+		}
+	}
+
+	result := make([]token, 0, len(d.tokens))
+
+	for i := 0; i < len(d.tokens); i++ {
+		tok := d.tokens[i]
+		if tok.kind != tokMarker {
+			result = append(result, tok)
+			continue
+		}
+		if kept[i] {
+			tok.kind = tokSymbol
+			result = append(result, tok)
+			continue
+		}
+
+		var prev token
+		if len(result) > 0 {
+			prev = result[len(result)-1]
+		}
+		next := i + 1
+		for next < len(d.tokens) && d.tokens[next].kind == tokMarker {
+			next++
+		}
+
+		end, replacement := i, ""
+		switch {
+		case next >= len(d.tokens):
+		case prev.kind == tokSpace && d.tokens[next].kind == tokSpace:
+			end = next
+		case len(result) > 0 && prev.isWord() && d.tokens[next].isWord():
+			result = append(result, token{kind: tokSpace, text: " ", pos: tok.pos})
+			replacement = " "
+		}
+		d.recordRemoval(i, end, replacement)
+		i = end
+	}
+
+	return result
+}
+
+// recordRemoval adds an edit for every marker render drops from tokens
+// i through end. The first one's replacement is the space left in its
+// place, if any, and the last one's original takes along the whitespace
+// dropped after it.
+func (d *document) recordRemoval(i, end int, replacement string) {
+	var last *Edit
+	for j := i; j <= end; j++ {
+		tok := d.tokens[j]
+		switch {
+		case tok.kind == tokMarker && tok.text != "":
+			d.edits.add(tok.name, tok.pos, tok.text, replacement)
+			last, replacement = &d.edits[len(d.edits)-1], ""
+		case tok.kind == tokSpace && last != nil:
+			last.Original += tok.text
+		}
+	}
+}
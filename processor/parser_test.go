@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+)
+
+// targetWords returns, for every marker in text, the words it targets.
+func targetWords(text string) [][]string {
+	doc := parse(text, defaultConfig())
+	var out [][]string
+	for _, m := range doc.markers {
+		var words []string
+		for _, s := range m.targets {
+			for j := s.start; j < s.end; j++ {
+				if doc.tokens[j].isWord() {
+					words = append(words, doc.tokens[j].text)
+				}
+			}
+		}
+		out = append(out, words)
+	}
+	return out
+}
+
+func TestParseTargets(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want [][]string
+	}{
+		{"previous word", "one two (up)", [][]string{{"two"}}},
+		{"count", "one two three (up, 2)", [][]string{{"two", "three"}}},
+		{"count larger than the line", "one two (up, 5)", [][]string{{"one", "two"}}},
+		{"skips punctuation", "one, (up)", [][]string{{"one"}}},
+		{"skips markers", "one (up) (low)", [][]string{{"one"}, {"one"}}},
+		{"quoted group", "say 'hello there' (up)", [][]string{{"hello", "there"}}},
+		{"parenthesized group", "say (hello there) (up)", [][]string{{"hello", "there"}}},
+		{"word scope stops at a group", "'1E' (hex)", [][]string{nil}},
+		{"stops at newline", "one\n(up)", [][]string{nil}},
+		{"count stops at newline", "one\ntwo (up, 2)", [][]string{{"two"}}},
+		{"number stays the target until applied", "word 42 (up)", [][]string{{"42"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targetWords(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targets in %q\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"3", 3},
+		{"0", 0},
+		{"-2", -2},
+		{"99999999999999999999", int(^uint(0) >> 1)},
+		{"-99999999999999999999", -int(^uint(0)>>1) - 1},
+	}
+	for _, tt := range tests {
+		if got := parseCount(tt.in); got != tt.want {
+			t.Errorf("parseCount(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPairs(t *testing.T) {
+	tokens := lex("'a (b)' (c", defaultConfig(), &diagnostics{})
+	pairs := matchPairs(tokens)
+	for i, tok := range tokens {
+		j := pairs[i]
+		switch {
+		case tok.text == "(" && tok.pos == 8:
+			if j != -1 {
+				t.Errorf("unclosed ( paired with %d", j)
+			}
+		case tok.kind == tokQuote || tok.kind == tokSymbol:
+			if j < 0 || pairs[j] != i {
+				t.Errorf("%q at %d is not paired both ways", tok.text, tok.pos)
+			}
+		}
+	}
+}
+
+func TestMarkerGroups(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"one two (cap, a (hex) (bin))", "One Two"},
+		{"me (up, 10(bin))", "ME"},
+		{"quiet (UP, (low) 1)", "QUIET"},
+		{"one two (cap, zz (hex))", "one two (cap, zz)"},
+		{"(cap in hand (up))", "(cap in HAND)"},
+	})
+}
+
+func TestMarkerGroupDiagnostics(t *testing.T) {
+	if r := Run("one two (cap, a (hex) (bin))"); len(r.Diagnostics) != 0 {
+		t.Errorf("got %v, want no diagnostics", r.Diagnostics)
+	}
+
+	r := Run("one two (cap, zz (hex))")
+	var codes []string
+	for _, d := range r.Diagnostics {
+		codes = append(codes, d.Code)
+	}
+	if want := []string{CodeBadArguments, CodeInvalidTarget}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("got %v, want %v", r.Diagnostics, want)
+	}
+}
@@ -1,455 +1,138 @@
 package processor
 
-import (
-	"regexp"
-	"strings"
-)
-
-func ProcessText(text string) string {
-	text = normalizeSpaces(text)
-
-	lines := strings.Split(text, "\n")
-	for i, line := range lines {
-		line = strings.TrimLeft(line, " ")
-		lines[i] = line
-	}
-	text = strings.Join(lines, "\n")
-
-	// First handle nested patterns like (cap(low)) and BIN(low)
-	text = processNestedPatterns(text)
-
-	// Then handle parenthesized character patterns like (L(low)O(low)W(low))
-	text = processParenthesizedCharPatterns(text)
-
-	// Handle adjacent character patterns like L(low)o(up)w(up)
-	text = processAdjacentCharPatterns(text)
-
-	// Handle patterns directly attached to words (no space)
-	text = processNoSpacePatterns(text)
-
-	// Handle special cases of adjacent patterns
-	text = processAdjacentCasePatterns(text)
-
-	// Process remaining patterns sequentially from LEFT TO RIGHT
-	text = processAllPatterns(text)
-
-	// Apply final formatting
-	text = formatPunctuation(text)
-
-	text = fixArticles(text)
-
-	text = normalizeSpaces(text)
-	lines = strings.Split(text, "\n")
-	for i, line := range lines {
-		line = strings.TrimLeft(line, " ")
-		lines[i] = line
-	}
-	text = strings.Join(lines, "\n")
-
-	return text
-}
-
-func processAllPatterns(text string) string {
-	for {
-		pattern, position := findLeftmostPattern(text)
-		if position == -1 {
-			break
-		}
-		text = applyAndRemovePattern(text, pattern, position)
-	}
-
-	return text
+// Processor applies markers and formatting rules to text. A Processor is
+// immutable once built and safe for concurrent use.
+type Processor struct {
+	cfg *config
 }
 
-func findLeftmostPattern(text string) (string, int) {
-	patterns := findAllPatterns(text)
-	if len(patterns) == 0 {
-		return "", -1
-	}
-
-	leftmost := patterns[0]
-	for _, p := range patterns {
-		if p.position < leftmost.position {
-			leftmost = p
-		}
-	}
+// Result is the outcome of running a Processor over some text.
+type Result struct {
+	Text        string
+	Diagnostics []Diagnostic
 
-	return leftmost.text, leftmost.position
-}
+	// Edits lists every change made to the text, in input order.
+	Edits []Edit
 
-type PatternMatch struct {
-	text     string
-	position int
-	command  string
-	count    string
+	// Trace is only set when the processor was built with WithTrace.
+	Trace *Trace
 }
 
-func findAllPatterns(text string) []PatternMatch {
-	var patterns []PatternMatch
-
-	simplePatternRegex := regexp.MustCompile(`\(\s*([hH][eE][xX]|[bB][iI][nN]|[uU][pP]|[lL][oO][wW]|[cC][aA][pP])\s*\)`)
-	simpleMatches := simplePatternRegex.FindAllStringIndex(text, -1)
-	for _, match := range simpleMatches {
-		patternText := text[match[0]:match[1]]
-		commandRaw := regexp.MustCompile(`([hH][eE][xX]|[bB][iI][nN]|[uU][pP]|[lL][oO][wW]|[cC][aA][pP])`).FindString(patternText)
-
-		// Normalize command to lowercase
-		command := strings.ToLower(commandRaw)
-
-		patterns = append(patterns, PatternMatch{
-			text:     patternText,
-			position: match[0],
-			command:  command,
-		})
+// New returns a Processor configured by opts. Without options every rule is
+// enabled and the locale is English.
+func New(opts ...Option) *Processor {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(cfg)
 	}
-
-	numberedRegex := regexp.MustCompile(`\(\s*([uU][pP]|[lL][oO][wW]|[cC][aA][pP])\s*,\s*(-?\d+)\s*\)`)
-	matches := numberedRegex.FindAllStringIndex(text, -1)
-	for _, match := range matches {
-		patternText := text[match[0]:match[1]]
-		cmdMatchesRaw := regexp.MustCompile(`([uU][pP]|[lL][oO][wW]|[cC][aA][pP])`).FindString(patternText)
-		countMatches := regexp.MustCompile(`-?\d+`).FindString(patternText)
-
-		// Normalize command to lowercase
-		cmdMatches := strings.ToLower(cmdMatchesRaw)
-
-		if strings.HasPrefix(countMatches, "-") {
-			patterns = append(patterns, PatternMatch{
-				text:     patternText,
-				position: match[0],
-				command:  "remove",
-				count:    countMatches,
-			})
-		} else {
-			patterns = append(patterns, PatternMatch{
-				text:     patternText,
-				position: match[0],
-				command:  cmdMatches,
-				count:    countMatches,
-			})
-		}
-	}
-
-	return patterns
+	return &Processor{cfg: cfg}
 }
 
-func applyAndRemovePattern(text, pattern string, position int) string {
-	var result string
-
-	patternLower := strings.ToLower(pattern)
-
-	switch {
-	case strings.Contains(patternLower, "hex"):
-		result = processHexAtPosition(text, position)
-	case strings.Contains(patternLower, "bin"):
-		result = processBinAtPosition(text, position)
-	case strings.Contains(patternLower, "up") && !strings.Contains(patternLower, ","):
-		result = processCaseAtPosition(text, position, "up", 1)
-	case strings.Contains(patternLower, "low") && !strings.Contains(patternLower, ","):
-		result = processCaseAtPosition(text, position, "low", 1)
-	case strings.Contains(patternLower, "cap") && !strings.Contains(patternLower, ","):
-		result = processCaseAtPosition(text, position, "cap", 1)
-	case strings.Contains(patternLower, ","):
-		result = processNumberedCasePattern(text, pattern, position)
-	default:
-		result = removePatternAt(text, pattern, position)
-	}
-
-	result = formatQuotes(result)
-
-	return result
+// Process applies every marker in text and then fixes spacing, quotes,
+// punctuation and articles, as far as the enabled rules allow.
+func (p *Processor) Process(text string) string {
+	return p.Run(text).Text
 }
 
-func processNoSpacePatterns(text string) string {
-	noSpaceRegex := regexp.MustCompile(`(\w+)\(\s*([uU][pP]|[lL][oO][wW]|[cC][aA][pP])\s*\)`)
+// Run is like Process but also reports markers that were malformed or could
+// not be applied.
+func (p *Processor) Run(text string) *Result {
+	cfg := p.cfg
 
-	type transformation struct {
-		start int
-		end   int
-		word  string
+	doc := parse(text, cfg)
+	if cfg.trace {
+		doc.trace = &Trace{}
 	}
-	var transformations []transformation
-
-	matches := noSpaceRegex.FindAllStringSubmatchIndex(text, -1)
-	if matches == nil {
-		return text
+	if cfg.enabled(RuleMarkers) {
+		doc.applyMarkers()
 	}
 
-	for _, match := range matches {
-		wordStart, wordEnd := match[2], match[3]
-		caseTypeStart, caseTypeEnd := match[4], match[5]
-
-		word := text[wordStart:wordEnd]
-		caseTypeRaw := text[caseTypeStart:caseTypeEnd]
-
-		// Normalize command to lowercase
-		caseType := strings.ToLower(caseTypeRaw)
-
-		var transformedWord string
-		switch caseType {
-		case "up":
-			transformedWord = strings.ToUpper(word)
-		case "low":
-			transformedWord = strings.ToLower(word)
-		case "cap":
-			transformedWord = capitalize(word)
-		default:
-			transformedWord = word
-		}
-
-		transformations = append(transformations, transformation{
-			start: wordStart,
-			end:   match[1],
-			word:  transformedWord,
-		})
+	tokens := layout(doc.render())
+	if doc.trace != nil && cfg.enabled(RuleMarkers) {
+		doc.trace.Stages = append(doc.trace.Stages, TraceStage{RuleMarkers, join(tokens)})
 	}
 
-	result := text
-	for i := len(transformations) - 1; i >= 0; i-- {
-		t := transformations[i]
-
-		if i < len(transformations)-1 && !isPunctuation(result[t.end]) {
-			result = result[:t.start] + t.word + " " + result[t.end:]
-		} else {
-			result = result[:t.start] + t.word + result[t.end:]
+	changes := doc.edits
+	for _, s := range formatStages {
+		if !cfg.enabled(s.rule) {
+			continue
 		}
-	}
-
-	return result
-}
-
-func processNestedPatterns(text string) string {
-	// Phase 1: Iteratively flatten semantic nested patterns (e.g., (cap(hex)) -> (cap))
-	// This regex matches (OUTER_CMD(INNER_CMD_PATTERN)) where INNER_CMD_PATTERN is a command in parentheses.
-	nestedTransformApplyRegex := regexp.MustCompile(`\(([a-zA-Z]+)\s*(\(hex\)|\(bin\)|\(up\)|\(low\)|\(cap\))\)`)
-
-	for {
-		foundFlattening := false
-		newText := nestedTransformApplyRegex.ReplaceAllStringFunc(text, func(fullMatch string) string {
-			subMatches := nestedTransformApplyRegex.FindStringSubmatch(fullMatch)
-			if len(subMatches) < 3 {
-				return fullMatch // Should not happen if regex matched
-			}
-
-			outerCmdRaw := subMatches[1]  // e.g., "cap", "hex"
-			innerPattern := subMatches[2] // e.g., "(hex)", "(up)"
-
-			outerCmd := strings.ToLower(outerCmdRaw)
-			innerCmd := strings.ToLower(strings.Trim(innerPattern, "()")) // Extract inner command (e.g., "hex" from "(hex)")
-
-			var resultCmd string
-
-			// Apply innerCmd to outerCmd if innerCmd is a case transformation
-			if innerCmd == "up" || innerCmd == "low" || innerCmd == "cap" {
-				resultCmd = applyCaseTransformation(outerCmd, innerCmd)
-			} else if outerCmd == "hex" || outerCmd == "bin" {
-				// If outer is hex/bin and inner is also hex/bin, or not a case transformation
-				// E.g., (hex(bin)), (hex(up)) -> should result in (hex) as 'up' doesn't transform 'hex' numerically
-				resultCmd = outerCmd
-			} else {
-				// Default for unhandled combinations, just pass through outer command
-				resultCmd = outerCmd
-			}
-
-			// If the outer command was transformed, mark as found change
-			if strings.ToLower(resultCmd) != strings.ToLower(outerCmd) {
-				foundFlattening = true
-			}
-
-			return "(" + resultCmd + ")"
-		})
-
-		if !foundFlattening {
-			break // No more nested command transformations applied in this pass
+		before := append([]token(nil), tokens...)
+		tokens = s.run(cfg, tokens)
+		changes.record(s.rule, before, tokens)
+		if doc.trace != nil {
+			doc.trace.Stages = append(doc.trace.Stages, TraceStage{s.rule, join(tokens)})
 		}
-		text = newText
 	}
 
-	// Phase 2: Handle patterns directly attached to words (no space) after flattening
-	// This section is for patterns like WORD(COMMAND) e.g., BIN(low)
-	commandWordRegex := regexp.MustCompile(`([hH][eE][xX]|[bB][iI][nN]|[uU][pP]|[lL][oO][wW]|[cC][aA][pP])\(\s*([uU][pP]|[lL][oO][wW]|[cC][aA][pP])\s*\)`)
-
-	for {
-		match := commandWordRegex.FindStringSubmatchIndex(text)
-		if match == nil {
-			break
-		}
-		word := text[match[2]:match[3]]        // This is the 'WORD' part of WORD(COMMAND)
-		caseTypeRaw := text[match[4]:match[5]] // This is the 'COMMAND' part
-
-		caseType := strings.ToLower(caseTypeRaw)
-		wordLower := strings.ToLower(word)
-
-		var transformedWord string
-		switch caseType {
-		case "up":
-			transformedWord = strings.ToUpper(wordLower)
-		case "low":
-			transformedWord = strings.ToLower(wordLower)
-		case "cap":
-			transformedWord = capitalize(wordLower)
-		default:
-			transformedWord = wordLower
+	diags := doc.diags.locate(text)
+	if cfg.strict {
+		for i := range diags {
+			diags[i].Severity = SeverityError
 		}
-
-		// Replace WORD(COMMAND) with TRANSFORMED_WORD
-		replacement := transformedWord
-		text = text[:match[0]] + replacement + text[match[1]:]
 	}
 
-	return text
-}
-
-func extractInnermostCommand(pattern string) string {
-	commandPattern := regexp.MustCompile(`\(([A-Za-z]+)\)`)
-	matches := commandPattern.FindAllStringSubmatch(pattern, -1)
-
-	if len(matches) == 0 {
-		return ""
-	}
-
-	// Reverting to original: take the last match, which is the innermost for simple (cmd) patterns
-	innermost := matches[len(matches)-1][1]
-
-	// Normalize command to lowercase for validation
-	if isValidCommand(innermost) {
-		return strings.ToLower(innermost)
+	return &Result{
+		Text:        join(tokens),
+		Diagnostics: diags,
+		Edits:       changes.locate(text),
+		Trace:       doc.trace,
 	}
-
-	return ""
 }
 
-func isValidCommand(cmd string) bool {
-	cmd = strings.ToLower(cmd)
-	validCommands := []string{"low", "up", "cap"}
-	for _, valid := range validCommands {
-		if cmd == valid {
-			return true
-		}
-	}
-	return false
+// stage is a formatting pass over the laid-out tokens, run when its rule
+// is enabled.
+type stage struct {
+	rule Rule
+	run  func(cfg *config, tokens []token) []token
 }
 
-func processAdjacentCasePatterns(text string) string {
-	adjacentRegex := regexp.MustCompile(`([a-zA-Z])\(\s*([uU][pP]|[lL][oO][wW]|[cC][aA][pP])\s*\)`)
-
-	for {
-		matches := adjacentRegex.FindAllStringSubmatchIndex(text, -1)
-		if len(matches) == 0 {
-			break
-		}
-
-		leftmostMatch := matches[0]
-		for _, match := range matches {
-			if match[0] < leftmostMatch[0] {
-				leftmostMatch = match
-			}
+var formatStages = []stage{
+	{RuleSpaces, func(cfg *config, tokens []token) []token {
+		normalizeSpaces(tokens)
+		formatParentheses(tokens)
+		return tokens
+	}},
+	{RuleQuotes, func(cfg *config, tokens []token) []token {
+		formatQuotes(tokens)
+		return tokens
+	}},
+	{RulePunctuation, func(cfg *config, tokens []token) []token {
+		return formatPunctuation(tokens)
+	}},
+	{RuleArticles, func(cfg *config, tokens []token) []token {
+		if cfg.locale == "en" && cfg.needsAn != nil {
+			fixArticles(tokens, cfg.needsAn)
 		}
-
-		match := leftmostMatch
-
-		charStart, charEnd := match[2], match[3]
-		caseTypeStart, caseTypeEnd := match[4], match[5]
-
-		char := text[charStart:charEnd]
-		caseTypeRaw := text[caseTypeStart:caseTypeEnd]
-
-		// Normalize command to lowercase
-		caseType := strings.ToLower(caseTypeRaw)
-
-		var transformedChar string
-		switch caseType {
-		case "up":
-			transformedChar = strings.ToUpper(char)
-		case "low":
-			transformedChar = strings.ToLower(char)
-		case "cap":
-			transformedChar = capitalize(char)
-		default:
-			transformedChar = char
-		}
-
-		patternEnd := match[1]
-		text = text[:charStart] + transformedChar + text[patternEnd:]
-	}
-
-	return text
+		return tokens
+	}},
 }
 
-func processParenthesizedCharPatterns(text string) string {
-	charPatternRegex := regexp.MustCompile(`\(([A-Za-z])\(\s*([uU][pP]|[lL][oO][wW]|[cC][aA][pP])\s*\)([A-Za-z])\(\s*([uU][pP]|[lL][oO][wW]|[cC][aA][pP])\s*\)([A-Za-z])\(\s*([uU][pP]|[lL][oO][wW]|[cC][aA][pP])\s*\)\)`)
-
-	for {
-		match := charPatternRegex.FindStringSubmatchIndex(text)
-		if match == nil {
-			break
+// Err returns a *DiagnosticsError if any diagnostic is an error, and nil
+// otherwise.
+func (r *Result) Err() error {
+	var errs []Diagnostic
+	for _, d := range r.Diagnostics {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
 		}
-
-		char1 := text[match[2]:match[3]]
-		caseRaw1 := text[match[4]:match[5]]
-		char2 := text[match[6]:match[7]]
-		caseRaw2 := text[match[8]:match[9]]
-		char3 := text[match[10]:match[11]]
-		caseRaw3 := text[match[12]:match[13]]
-
-		// Normalize commands to lowercase
-		case1 := strings.ToLower(caseRaw1)
-		case2 := strings.ToLower(caseRaw2)
-		case3 := strings.ToLower(caseRaw3)
-
-		transformed1 := applyCaseTransformation(char1, case1)
-		transformed2 := applyCaseTransformation(char2, case2)
-		transformed3 := applyCaseTransformation(char3, case3)
-
-		replacement := "(" + transformed1 + " " + transformed2 + " " + transformed3 + ")"
-
-		text = text[:match[0]] + replacement + text[match[1]:]
 	}
 
-	return text
-}
-
-func applyCaseTransformation(word, caseType string) string {
-	switch caseType {
-	case "up":
-		return strings.ToUpper(word)
-	case "low":
-		return strings.ToLower(word)
-	case "cap":
-		return capitalize(word)
-	default:
-		return word
+	if len(errs) == 0 {
+		return nil
 	}
+	return &DiagnosticsError{First: errs[0], Count: len(errs)}
 }
 
-func processAdjacentCharPatterns(text string) string {
-	charPatternRegex := regexp.MustCompile(`([a-zA-Z])\(\s*([uU][pP]|[lL][oO][wW]|[cC][aA][pP])\s*\)([a-zA-Z])\(\s*([uU][pP]|[lL][oO][wW]|[cC][aA][pP])\s*\)([a-zA-Z])\(\s*([uU][pP]|[lL][oO][wW]|[cC][aA][pP])\s*\)`)
-
-	for {
-		match := charPatternRegex.FindStringSubmatchIndex(text)
-		if match == nil {
-			break
-		}
-
-		char1 := text[match[2]:match[3]]
-		caseRaw1 := text[match[4]:match[5]]
-		char2 := text[match[6]:match[7]]
-		caseRaw2 := text[match[8]:match[9]]
-		char3 := text[match[10]:match[11]]
-		caseRaw3 := text[match[12]:match[13]]
-
-		// Normalize commands to lowercase
-		case1 := strings.ToLower(caseRaw1)
-		case2 := strings.ToLower(caseRaw2)
-		case3 := strings.ToLower(caseRaw3)
-
-		transformed1 := applyCaseTransformation(char1, case1)
-		transformed2 := applyCaseTransformation(char2, case2)
-		transformed3 := applyCaseTransformation(char3, case3)
+var defaultProcessor = New()
 
-		replacement := transformed1 + transformed2 + transformed3
-		text = text[:match[0]] + replacement + text[match[1]:]
-	}
+// ProcessText runs text through a Processor with the default options.
+func ProcessText(text string) string {
+	return defaultProcessor.Process(text)
+}
 
-	return text
+// Run runs text through a Processor with the default options and returns
+// the diagnostics along with the output.
+func Run(text string) *Result {
+	return defaultProcessor.Run(text)
 }
@@ -0,0 +1,153 @@
+package processor
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Reasons the output for sample.txt differs from res.txt.
+const (
+	trailingSpace    = "whitespace at the end of a line is removed"
+	collapsedSpace   = "runs of spaces between words are collapsed"
+	spaceBeforeQuote = "an opening quote after ':' or '.' keeps its space"
+	unpairedQuote    = "quotes pair left to right, and an unpaired quote is left as it is"
+	parenSpace       = "whitespace just inside parentheses is removed"
+	keptPunctuation  = "punctuation between a word and its marker is kept"
+	exactNumbers     = "numbers too large for int64 are converted exactly"
+)
+
+// resDifferences lists the lines where the output for sample.txt knowingly
+// differs from res.txt. res.txt was written by hand for the regex-based
+// processor and covers only the first lines of sample.txt.
+var resDifferences = map[int]string{
+	1: unpairedQuote, 2: unpairedQuote, 4: unpairedQuote,
+	28: trailingSpace, 31: trailingSpace,
+	50: spaceBeforeQuote, 51: spaceBeforeQuote, 53: spaceBeforeQuote, 54: spaceBeforeQuote,
+	68: spaceBeforeQuote, 69: spaceBeforeQuote,
+	115: trailingSpace, 121: trailingSpace,
+	125: parenSpace,
+	127: trailingSpace, 130: trailingSpace, 131: trailingSpace,
+	133: trailingSpace, 136: trailingSpace,
+	139: trailingSpace, 142: trailingSpace,
+	145: collapsedSpace,
+	154: trailingSpace, 155: trailingSpace, 176: trailingSpace, 179: trailingSpace,
+	182: trailingSpace, 197: trailingSpace,
+	215: collapsedSpace, 218: collapsedSpace,
+	222: unpairedQuote, 223: unpairedQuote, 245: unpairedQuote, 246: unpairedQuote,
+	248: keptPunctuation, 252: keptPunctuation,
+	254: trailingSpace,
+	262: spaceBeforeQuote, 263: spaceBeforeQuote,
+	265: trailingSpace, 274: trailingSpace, 276: trailingSpace, 291: trailingSpace,
+	295: trailingSpace, 296: trailingSpace,
+	298: unpairedQuote, 305: unpairedQuote, 308: unpairedQuote,
+	321: trailingSpace,
+	322: exactNumbers, 326: exactNumbers,
+	339: spaceBeforeQuote,
+	346: trailingSpace, 347: trailingSpace, 348: trailingSpace, 349: trailingSpace,
+	350: trailingSpace, 351: trailingSpace, 352: trailingSpace, 353: trailingSpace,
+	354: trailingSpace, 355: trailingSpace,
+	358: unpairedQuote, 368: unpairedQuote, 369: unpairedQuote, 370: unpairedQuote,
+	375: trailingSpace,
+	381: trailingSpace,
+	382: trailingSpace, 386: trailingSpace, 387: trailingSpace, 389: trailingSpace,
+	392: trailingSpace,
+	396: parenSpace,
+	398: trailingSpace,
+	400: unpairedQuote,
+	401: trailingSpace,
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestSampleGolden(t *testing.T) {
+	got := ProcessText(readFile(t, "../sample.txt"))
+	if *update {
+		if err := os.WriteFile("testdata/sample.golden", []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := readFile(t, "testdata/sample.golden")
+	gotLines, wantLines := strings.Split(got, "\n"), strings.Split(want, "\n")
+	for i := 0; i < max(len(gotLines), len(wantLines)); i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			t.Errorf("line %d\n got %q\nwant %q", i+1, g, w)
+		}
+	}
+}
+
+func TestSampleMatchesRes(t *testing.T) {
+	got := strings.Split(ProcessText(readFile(t, "../sample.txt")), "\n")
+	res := strings.Split(strings.TrimSuffix(readFile(t, "../res.txt"), "\n"), "\n")
+
+	for i, want := range res {
+		line := i + 1
+		reason, known := resDifferences[line]
+		switch {
+		case got[i] != want && !known:
+			t.Errorf("line %d differs from res.txt\n got %q\nwant %q", line, got[i], want)
+		case got[i] == want && known:
+			t.Errorf("line %d now matches res.txt; remove it from resDifferences (%s)", line, reason)
+		}
+	}
+}
+
+func TestProcessText(t *testing.T) {
+	in := "it (cap) was the BEST (low) of times, it was the worst of TIMES (low) ,it was the age of wisdom , it was the age of foolishness (cap, 6) ."
+	want := "It was the best of times, it was the worst of times, it was the age of wisdom, It Was The Age Of Foolishness."
+	if got := ProcessText(in); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	tests := []struct {
+		in, want, code string
+	}{
+		{"name 42 (cap)", "name 42", CodeRetargeted},
+		{"zz (hex)", "zz (hex)", CodeInvalidTarget},
+		{"word (up, 0)", "word", CodeBadCount},
+		{"(up) word", "word", CodeNoTarget},
+	}
+
+	p := New(WithStrict(true))
+	for _, tt := range tests {
+		r := p.Run(tt.in)
+		if r.Text != tt.want {
+			t.Errorf("Run(%q).Text = %q, want %q", tt.in, r.Text, tt.want)
+		}
+		var diagErr *DiagnosticsError
+		if err := r.Err(); !errors.As(err, &diagErr) || diagErr.First.Code != tt.code || diagErr.First.Severity != SeverityError {
+			t.Errorf("Run(%q).Err() = %v, want a %s error", tt.in, err, tt.code)
+		}
+	}
+
+	if r := p.Run("fine (up)"); r.Text != "FINE" || r.Err() != nil {
+		t.Errorf("got %q, %v; want FINE and no error", r.Text, r.Err())
+	}
+}
+
+func TestErrIgnoresWarnings(t *testing.T) {
+	if err := Run("name 42 (cap)").Err(); err != nil {
+		t.Errorf("got %v, want nil outside strict mode", err)
+	}
+}
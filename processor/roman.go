@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var romanNumerals = []struct {
+	value  int
+	symbol string
+}{
+	{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"},
+	{100, "C"}, {90, "XC"}, {50, "L"}, {40, "XL"},
+	{10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+}
+
+var romanValues = map[rune]int{'I': 1, 'V': 5, 'X': 10, 'L': 50, 'C': 100, 'D': 500, 'M': 1000}
+
+var romanRegex = regexp.MustCompile(`^M{0,3}(CM|CD|D?C{0,3})(XC|XL|L?X{0,3})(IX|IV|V?I{0,3})$`)
+
+// romanMarker implements "(roman)": a whole number from 1 to 3999 becomes a
+// Roman numeral.
+func romanMarker(call *Call, words []string) ([]string, error) {
+	value, ok := parseLiteral(words[0], 10, call.thousands)
+	if !ok || !value.IsInt() {
+		return nil, fmt.Errorf("%q is not a whole number", words[0])
+	}
+	n := value.Num()
+	if !n.IsInt64() || n.Int64() < 1 || n.Int64() > 3999 {
+		return nil, fmt.Errorf("%s has no Roman numeral; only 1 to 3999 do", words[0])
+	}
+	return []string{toRoman(int(n.Int64()))}, nil
+}
+
+// fromRomanMarker implements "(fromroman)". Only numerals in standard form
+// are accepted; for others, such as IIII, the error suggests the standard
+// spelling of the same value.
+func fromRomanMarker(call *Call, words []string) ([]string, error) {
+	numeral := strings.ToUpper(words[0])
+	if numeral != "" && romanRegex.MatchString(numeral) {
+		return []string{groupThousands(fmt.Sprint(romanValue(numeral)), call.thousands)}, nil
+	}
+
+	if value := romanValue(numeral); value > 0 && value <= 3999 {
+		return nil, fmt.Errorf("%q is not a valid Roman numeral; did you mean %s?", words[0], toRoman(value))
+	}
+	return nil, fmt.Errorf("%q is not a valid Roman numeral", words[0])
+}
+
+func toRoman(n int) string {
+	var b strings.Builder
+	for _, r := range romanNumerals {
+		for n >= r.value {
+			b.WriteString(r.symbol)
+			n -= r.value
+		}
+	}
+	return b.String()
+}
+
+// romanValue adds up the symbols of an upper-case numeral, subtracting a
+// symbol that comes before a larger one, without checking the numeral is
+// well formed. It returns 0 if s has other characters.
+func romanValue(s string) int {
+	total := 0
+	runes := []rune(s)
+	for i, r := range runes {
+		v, ok := romanValues[r]
+		if !ok {
+			return 0
+		}
+		if i+1 < len(runes) && v < romanValues[runes[i+1]] {
+			total -= v
+		} else {
+			total += v
+		}
+	}
+	return total
+}
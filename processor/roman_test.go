@@ -0,0 +1,34 @@
+package processor
+
+import "testing"
+
+func TestRomanMarkers(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"Chapter 14 (roman).", "Chapter XIV."},
+		{"3999 (roman)", "MMMCMXCIX"},
+		{"4000 (roman)", "4000"},
+		{"0 (roman)", "0"},
+		{"1.5 (roman)", "1.5"},
+		{"xiv (fromroman)", "14"},
+		{"MCMXCIV (fromroman)", "1994"},
+		{"IIII (fromroman)", "IIII"},
+	})
+}
+
+func TestRomanRoundTrip(t *testing.T) {
+	for n := 1; n <= 3999; n++ {
+		numeral := toRoman(n)
+		if !romanRegex.MatchString(numeral) || romanValue(numeral) != n {
+			t.Fatalf("toRoman(%d) = %s, which does not read back", n, numeral)
+		}
+	}
+}
+
+func TestInvalidRomanNumerals(t *testing.T) {
+	runInvalid(t, New(), []struct{ in, message string }{
+		{"IIII (fromroman)", "did you mean IV?"},
+		{"IC (fromroman)", "did you mean XCIX?"},
+		{"ABC (fromroman)", `"ABC" is not a valid Roman numeral`},
+		{"MMMM (fromroman)", `"MMMM" is not a valid Roman numeral`},
+	})
+}
@@ -0,0 +1,148 @@
+package processor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ProcessStream copies r to w through the processor one line at a time, so
+// memory use is bounded by the longest line or block rather than the whole
+// input. Apart from (raw) and "(up:begin) ... (up:end)" blocks, which are
+// read in one piece, markers, quotes and articles never reach across a
+// line break, so the output is the same as Process would give for the
+// whole text.
+func (p *Processor) ProcessStream(ctx context.Context, r io.Reader, w io.Writer) error {
+	return p.RunStream(ctx, r, w, nil)
+}
+
+// RunStream is like ProcessStream but passes every diagnostic to report as
+// soon as it is found, with Offset and Line relative to the whole stream.
+// report may be nil. In strict mode a *DiagnosticsError is returned once the
+// rest of the stream has been written.
+func (p *Processor) RunStream(ctx context.Context, r io.Reader, w io.Writer, report func(Diagnostic)) error {
+	reader := bufio.NewReader(r)
+	writer := bufio.NewWriter(w)
+
+	blocks := &blockTracker{cfg: p.cfg, open: map[string]int{}}
+	var firstErr *Diagnostic
+	errCount := 0
+	offset, line := 0, 1
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		text, readErr := reader.ReadString('\n')
+		blocks.scan(text)
+		if readErr == nil && blocks.inBlock() {
+			var chunk strings.Builder
+			chunk.WriteString(text)
+			for readErr == nil && blocks.inBlock() {
+				text, readErr = reader.ReadString('\n')
+				blocks.scan(text)
+				chunk.WriteString(text)
+			}
+			text = chunk.String()
+		}
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return readErr
+		}
+		if text == "" {
+			break
+		}
+
+		result := p.Run(text)
+		if _, err := writer.WriteString(result.Text); err != nil {
+			return err
+		}
+
+		for _, d := range result.Diagnostics {
+			d.Offset += offset
+			d.Line += line - 1
+			if d.Severity == SeverityError {
+				if firstErr == nil {
+					firstErr = &d
+				}
+				errCount++
+			}
+			if report != nil {
+				report(d)
+			}
+		}
+
+		offset += len(text)
+		line += strings.Count(text, "\n")
+		if readErr != nil {
+			break
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	if firstErr == nil {
+		return nil
+	}
+	return &DiagnosticsError{First: *firstErr, Count: errCount}
+}
+
+// blockTracker follows the (raw) and marker blocks left open at the end of
+// each line of a stream, so that RunStream can process a block in one
+// piece.
+type blockTracker struct {
+	cfg   *config
+	inRaw bool
+	open  map[string]int
+}
+
+func (b *blockTracker) scan(line string) {
+	if b.inRaw {
+		end := indexRawClose(line)
+		if end < 0 {
+			return
+		}
+		line = line[end+len(rawClose):]
+		b.inRaw = false
+	}
+	if i := unclosedRaw(line); i >= 0 {
+		b.inRaw = true
+		line = line[:i]
+	}
+	// Every block tag has a ':', so most lines need not be lexed again.
+	if !b.cfg.enabled(RuleMarkers) || !strings.Contains(line, ":") {
+		return
+	}
+
+	for _, tok := range lex(line, b.cfg, &diagnostics{}) {
+		switch {
+		case tok.kind != tokMarker:
+		case tok.block == blockBegin:
+			b.open[tok.name]++
+		case tok.block == blockEnd && b.open[tok.name] > 0:
+			b.open[tok.name]--
+		}
+	}
+}
+
+func (b *blockTracker) inBlock() bool {
+	if b.inRaw {
+		return true
+	}
+	for _, n := range b.open {
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessStream runs r through a Processor with the default options and
+// writes the result to w.
+func ProcessStream(ctx context.Context, r io.Reader, w io.Writer) error {
+	return defaultProcessor.ProcessStream(ctx, r, w)
+}
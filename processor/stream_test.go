@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestProcessStreamMatchesProcess(t *testing.T) {
+	inputs := []string{
+		"",
+		"no newline at the end (up)",
+		"one (up)\ntwo (cap)\n",
+		"\n\nblank lines ,here\n\n",
+		"a apple\nan pear\n",
+		readFile(t, "../sample.txt"),
+	}
+
+	for _, in := range inputs {
+		var out strings.Builder
+		if err := ProcessStream(context.Background(), strings.NewReader(in), &out); err != nil {
+			t.Fatalf("ProcessStream: %v", err)
+		}
+		if want := ProcessText(in); out.String() != want {
+			t.Errorf("ProcessStream(%.40q)\n got %q\nwant %q", in, out.String(), want)
+		}
+	}
+}
+
+func TestRunStreamDiagnostics(t *testing.T) {
+	in := "fine\nzz (hex)\nmore (up, 0)"
+	var got []Diagnostic
+	err := New().RunStream(context.Background(), strings.NewReader(in), io.Discard, func(d Diagnostic) {
+		got = append(got, d)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream positions must match those of a single Run over the text.
+	want := Run(in).Diagnostics
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("diagnostic %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRunStreamStrict(t *testing.T) {
+	var out strings.Builder
+	err := New(WithStrict(true)).RunStream(context.Background(), strings.NewReader("zz (hex)\nok (up)\nqq (bin)\n"), &out, nil)
+
+	var diagErr *DiagnosticsError
+	if !errors.As(err, &diagErr) || diagErr.Count != 2 || diagErr.First.Line != 1 {
+		t.Errorf("got %v, want 2 errors starting on line 1", err)
+	}
+	if want := "zz (hex)\nOK\nqq (bin)\n"; out.String() != want {
+		t.Errorf("the rest of the stream was not written: got %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunStreamCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := New().RunStream(ctx, strings.NewReader("text\n"), io.Discard, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestRunStreamReadError(t *testing.T) {
+	boom := errors.New("boom")
+	err := ProcessStream(context.Background(), iotest.ErrReader(boom), io.Discard)
+	if !errors.Is(err, boom) {
+		t.Errorf("got %v, want %v", err, boom)
+	}
+}
+
+func TestProcessStreamBlocks(t *testing.T) {
+	inputs := []string{
+		"(up:begin) one\ntwo\n\nthree (up:end) four\nfive (up)\n",
+		"(up:begin) never\nclosed (low)\n",
+		"(raw)\nkeep (up)\n(/raw)\nnext (up)\n",
+		"a (low:begin) B\n(up:begin) C (up:end)\nD (low:end) E\n",
+	}
+
+	for _, in := range inputs {
+		var out strings.Builder
+		if err := ProcessStream(context.Background(), iotest.OneByteReader(strings.NewReader(in)), &out); err != nil {
+			t.Fatalf("ProcessStream: %v", err)
+		}
+		if want := ProcessText(in); out.String() != want {
+			t.Errorf("ProcessStream(%q)\n got %q\nwant %q", in, out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package processor
+
+// Trace shows how a Processor arrived at its output. It is only recorded
+// when the processor is built with WithTrace.
+type Trace struct {
+	// Steps lists the markers in the order they were applied.
+	Steps []TraceStep
+
+	// Stages holds the text after each enabled rule, in the order the
+	// rules run. Stages that changed nothing are included too.
+	Stages []TraceStage
+}
+
+// TraceStep records what one marker did. Edits is empty when the marker
+// changed nothing or could not be applied.
+type TraceStep struct {
+	Marker string
+	Offset int
+	Line   int
+	Column int
+	Edits  []Edit
+}
+
+// TraceStage is the text as it stood after one rule ran.
+type TraceStage struct {
+	Rule Rule
+	Text string
+}
+
+// traceStep records the edits a marker made, located in text.
+func traceStep(m *markerNode, made []Edit, text string) TraceStep {
+	step := TraceStep{Marker: m.text, Offset: m.pos, Edits: append([]Edit(nil), made...)}
+	step.Line, step.Column = position(text, m.pos)
+	for i := range step.Edits {
+		step.Edits[i].Line, step.Edits[i].Column = position(text, step.Edits[i].Offset)
+	}
+	return step
+}
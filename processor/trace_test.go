@@ -0,0 +1,46 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	r := New(WithTrace(true)).Run("it (cap) ,zz (hex) a apple")
+
+	wantSteps := []TraceStep{
+		{"(cap)", 3, 1, 4, []Edit{{"cap", "it", "It", 0, 1, 1}}},
+		{"(hex)", 13, 1, 14, nil},
+	}
+	if !reflect.DeepEqual(r.Trace.Steps, wantSteps) {
+		t.Errorf("steps\n got %+v\nwant %+v", r.Trace.Steps, wantSteps)
+	}
+
+	wantStages := []TraceStage{
+		{RuleMarkers, "It ,zz a apple"},
+		{RuleSpaces, "It ,zz a apple"},
+		{RuleQuotes, "It ,zz a apple"},
+		{RulePunctuation, "It, zz a apple"},
+		{RuleArticles, "It, zz an apple"},
+	}
+	if !reflect.DeepEqual(r.Trace.Stages, wantStages) {
+		t.Errorf("stages\n got %+v\nwant %+v", r.Trace.Stages, wantStages)
+	}
+}
+
+func TestTraceFollowsRules(t *testing.T) {
+	r := New(WithTrace(true), WithRules(RuleMarkers, RuleArticles)).Run("a apple (up)")
+	var rules []Rule
+	for _, s := range r.Trace.Stages {
+		rules = append(rules, s.Rule)
+	}
+	if want := []Rule{RuleMarkers, RuleArticles}; !reflect.DeepEqual(rules, want) {
+		t.Errorf("got stages %v, want %v", rules, want)
+	}
+}
+
+func TestNoTraceByDefault(t *testing.T) {
+	if r := Run("x (up)"); r.Trace != nil {
+		t.Errorf("got %+v, want no trace", r.Trace)
+	}
+}
@@ -2,505 +2,640 @@ package processor
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"regexp"
 	"strconv"
 	"strings"
 	"unicode"
 )
 
-func processHexAtPosition(text string, pos int) string {
-	word, wordStart, _, _, _ := findWordBefore(text, pos)
-	if word != "" {
-		hasPunctuation := false
-		punctuationChar := byte(0)
-		punctuationPos := -1
-
-		for i := 0; i < len(word); i++ {
-			if isPunctuation(word[i]) {
-				hasPunctuation = true
-				punctuationChar = word[i]
-				punctuationPos = i
-				break
+var countArgs = []Arg{{Name: "count", Kind: ArgCount, Optional: true}}
+
+// widthArgs is the optional "sN" of "(hex, s16)", which reads or writes
+// the number as an N-bit two's-complement value.
+var widthArgs = []Arg{{Name: "sN", Kind: ArgText, Optional: true}}
+
+func builtinMarkers() []Marker {
+	return []Marker{
+		NewMarker("hex", ScopeWord, widthArgs, convertMarker(16, 10)),
+		NewMarker("bin", ScopeWord, widthArgs, convertMarker(2, 10)),
+		NewMarker("oct", ScopeWord, widthArgs, convertMarker(8, 10)),
+		NewMarker("base", ScopeWord, []Arg{{Name: "base", Kind: ArgInt}, widthArgs[0]}, baseMarker),
+		NewMarker("tohex", ScopeWord, widthArgs, convertMarker(10, 16)),
+		NewMarker("tobin", ScopeWord, widthArgs, convertMarker(10, 2)),
+		NewMarker("tooct", ScopeWord, widthArgs, convertMarker(10, 8)),
+		NewMarker("f32hex", ScopeWord, nil, floatMarker(32)),
+		NewMarker("f64hex", ScopeWord, nil, floatMarker(64)),
+		NewMarker("roman", ScopeWord, nil, romanMarker),
+		NewMarker("fromroman", ScopeWord, nil, fromRomanMarker),
+		NewMarker("words", ScopeWord, nil, wordsMarker),
+		NewMarker("ordinal", ScopeWord, nil, ordinalMarker),
+		NewMarker("num", ScopeWord, countArgs, numMarker),
+		NewMarker("calc", ScopeExpression, nil, calcMarker),
+		NewMarker("up", ScopeText, countArgs, caseMarker("up")),
+		NewMarker("low", ScopeText, countArgs, caseMarker("low")),
+		NewMarker("cap", ScopeText, countArgs, caseMarker("cap")),
+		NewMarker("snake", ScopeText, countArgs, identifierMarker("snake")),
+		NewMarker("camel", ScopeText, countArgs, identifierMarker("camel")),
+		NewMarker("kebab", ScopeText, countArgs, identifierMarker("kebab")),
+		NewMarker("pascal", ScopeText, countArgs, identifierMarker("pascal")),
+		NewMarker("constant", ScopeText, countArgs, identifierMarker("constant")),
+	}
+}
+
+// applyMarkers runs every marker from left to right. Each marker sees the
+// text as rewritten by the markers before it, so "a (hex) (bin)" works,
+// and a marker group is read once the markers in its arguments have run.
+func (d *document) applyMarkers() {
+	for _, m := range d.markers {
+		n := len(d.edits)
+		if m.group != nil {
+			if !d.readGroup(m) {
+				continue
 			}
+			d.applyMarker(m)
+			if !m.keep {
+				d.removeGroup(m)
+			}
+		} else {
+			d.applyMarker(m)
 		}
-
-		hexPart := word
-		if hasPunctuation && punctuationPos > 0 {
-			hexPart = word[:punctuationPos]
+		if d.trace != nil {
+			d.trace.Steps = append(d.trace.Steps, traceStep(m, d.edits[n:], d.text))
 		}
+	}
+}
 
-		if isHex(hexPart) {
-			if hexValue, err := strconv.ParseUint(hexPart, 16, 64); err == nil {
-				if hexValue <= 0x7FFFFFFFFFFFFFFF {
-					if decimal, err := strconv.ParseInt(hexPart, 16, 64); err == nil {
-						before := text[:wordStart]
-						after := text[pos+5:]
-
-						result := before + strconv.FormatInt(decimal, 10)
-						if hasPunctuation {
-							result += string(punctuationChar)
-						}
-						result += after
-
-						return result
-					}
-				}
-			}
+// applyMarker hands the words a marker targets to its Apply function and
+// writes the result back. A marker that fails leaves the text unchanged;
+// in strict mode the marker itself stays in the output as well. A block
+// of a ScopeWord marker, such as (hex:begin), applies it to each word in
+// the block separately.
+func (d *document) applyMarker(m *markerNode) {
+	if m.hasCount && m.count <= 0 {
+		return
+	}
 
-			before := text[:wordStart]
-			after := text[pos+5:]
-			result := before + hexPart
-			if hasPunctuation {
-				result += string(punctuationChar)
-			}
-			result += after
+	targets := m.targets
+	if !m.hasCount && !m.block && m.marker.Scope() == ScopeText && len(targets) == 1 && isNumericSpan(d, targets[0]) {
+		number := d.tokens[targets[0].start].text
+		relation, other := "follows", "precedes"
+		if m.forward {
+			relation, other = "precedes", "follows"
+		}
+		if d.cfg.strict {
+			d.diags.add(SeverityWarning, CodeRetargeted, m.pos, "%s %s the number %q, which has no case", m.text, relation, number)
+			return
+		}
 
-			return result
+		target, ok := d.lastTextWordBefore(targets[0].start)
+		if m.forward {
+			target, ok = d.firstTextWordAfter(targets[0].start)
+		}
+		if !ok {
+			d.diags.add(SeverityWarning, CodeNoTarget, m.pos, "%s %s the number %q and no word %s it", m.text, relation, number, other)
+			return
 		}
+		d.diags.add(SeverityWarning, CodeRetargeted, m.pos, "%s %s the number %q; applied to %q instead", m.text, relation, number, d.tokens[target.start].text)
+		targets = []span{target}
 	}
-	return removePatternAt(text, "(hex)", pos)
-}
 
-func processBinAtPosition(text string, pos int) string {
-	word, wordStart, _, _, _ := findWordBefore(text, pos)
-	if word != "" {
-		hasPunctuation := false
-		punctuationChar := byte(0)
-		punctuationPos := -1
-
-		for i := 0; i < len(word); i++ {
-			if isPunctuation(word[i]) {
-				hasPunctuation = true
-				punctuationChar = word[i]
-				punctuationPos = i
-				break
+	// An expression marker reads operators and parentheses too.
+	expression := m.marker.Scope() == ScopeExpression
+	var indices []int
+	for _, s := range targets {
+		for j := s.start; j < s.end; j++ {
+			kind := d.tokens[j].kind
+			if d.tokens[j].isWord() || expression && (kind == tokSymbol || kind == tokPunct) {
+				indices = append(indices, j)
 			}
 		}
-
-		binPart := word
-		if hasPunctuation && punctuationPos > 0 {
-			binPart = word[:punctuationPos]
+	}
+	if len(indices) == 0 {
+		where := "before it"
+		switch {
+		case m.block:
+			where = "in its block"
+		case m.forward:
+			where = "after it"
 		}
+		what := "word"
+		if expression {
+			what = "expression"
+		}
+		d.diags.add(SeverityWarning, CodeNoTarget, m.pos, "%s has no %s %s to apply to", m.text, what, where)
+		return
+	}
 
-		if isBin(binPart) {
-			if decimal, err := strconv.ParseInt(binPart, 2, 64); err == nil {
-				before := text[:wordStart]
-				after := text[pos+5:]
-
-				result := before + strconv.FormatInt(decimal, 10)
-				if hasPunctuation {
-					result += string(punctuationChar)
-				}
-				result += after
-
-				return result
-			}
+	if m.block && m.marker.Scope() == ScopeWord {
+		for _, j := range indices {
+			d.replaceWords(m, []int{j}, d.tokens[j].pos)
 		}
+		return
 	}
-	return removePatternAt(text, "(bin)", pos)
+	d.replaceWords(m, indices, m.pos)
 }
 
-func processCaseAtPosition(text string, pos int, caseType string, count int) string {
-	words, positions, quotedFlags, quoteChars := findWordsBefore(text, pos, count)
-
-	// NEW LOGIC FOR PROBLEM 1: Apply to last non-numeric word if target is numeric and count is 1 for case transformation
-	// Only apply this logic for single word case transformations (count == 1)
-	if count == 1 && (caseType == "up" || caseType == "low" || caseType == "cap") && len(words) > 0 && isNumeric(words[0]) {
-		// Try to find a non-numeric word on the line before the current numeric word's position
-		targetWord, targetStart, targetEnd, targetQuoted, targetQuoteChar := findLastNonNumericWordOnLineBefore(text, positions[0][0])
-
-		if targetWord != "" {
-			// Update the first word in the 'words', 'positions', etc. slices to reflect the new target word
-			words[0] = targetWord
-			positions[0][0] = targetStart
-			positions[0][1] = targetEnd
-			quotedFlags[0] = targetQuoted
-			quoteChars[0] = targetQuoteChar
-		} else {
-			// If no non-numeric word found to apply transformation, just remove the pattern
-			return removePatternAt(text, fmt.Sprintf("(%s)", caseType), pos)
-		}
+// replaceWords applies m to the words at the given token indices,
+// reporting a failure at pos.
+func (d *document) replaceWords(m *markerNode, indices []int, pos int) {
+	words := make([]string, len(indices))
+	for i, j := range indices {
+		words[i] = d.tokens[j].text
 	}
 
-	if len(words) > 0 {
-		transformedWords := make([]string, len(words))
-		for i, word := range words {
-			switch caseType {
-			case "up":
-				transformedWords[i] = strings.ToUpper(word)
-			case "cap":
-				transformedWords[i] = capitalize(word)
-			case "low":
-				transformedWords[i] = strings.ToLower(word)
-			default:
-				transformedWords[i] = word
-			}
+	call := &Call{Args: m.args, Count: m.count, Locale: d.cfg.locale, caseRules: d.cfg.caseRules, thousands: d.cfg.thousands, precision: d.cfg.precision}
+	replaced, err := m.marker.Apply(call, words)
+	if err == nil && len(replaced) < len(indices) {
+		if sep, ok := d.mergeBarrier(indices[:min(len(indices)-len(replaced)+1, len(indices))]); ok {
+			err = fmt.Errorf("cannot join words separated by %q", sep.text)
 		}
+	}
+	if err != nil {
+		d.diags.add(SeverityWarning, CodeInvalidTarget, pos, "%s: %v", m.text, err)
+		if d.cfg.strict {
+			m.keep = true
+		}
+		return
+	}
+
+	// A marker may merge words by returning fewer than it was given. The
+	// result goes to the last targets, where later markers look for it, and
+	// the leading words are removed along with the whitespace after them.
+	// Extra words stay together in the last target.
+	texts := replaced
+	if len(replaced) > len(indices) {
+		texts = append(replaced[:len(indices)-1:len(indices)-1], strings.Join(replaced[len(indices)-1:], " "))
+	}
+	removed := len(indices) - len(texts)
 
-		result := text[:positions[0][0]]
+	for i, j := range indices {
+		text := ""
+		if i >= removed {
+			text = texts[i-removed]
+		}
+		if text != d.tokens[j].text {
+			d.edits.add(m.name, d.tokens[j].pos, d.tokens[j].text, text)
+		}
 
-		for i, word := range transformedWords {
-			if quotedFlags[i] {
-				if quoteChars[i] == '(' {
-					result += "(" + word + ")"
-				} else {
-					result += string(quoteChars[i]) + word + string(quoteChars[i])
+		if i < removed {
+			d.tokens[j] = token{kind: tokSpace, pos: d.tokens[j].pos}
+			for k := j + 1; i+1 < len(indices) && k < indices[i+1]; k++ {
+				if d.tokens[k].kind == tokSpace {
+					d.tokens[k].text = ""
 				}
-			} else {
-				result += word
 			}
+			continue
+		}
+		// A word replaced by nothing is gone, and so is its token; an
+		// empty word would trip up the rules that read its first letter.
+		if text == "" {
+			d.tokens[j] = token{kind: tokSpace, pos: d.tokens[j].pos}
+			continue
+		}
 
-			if i < len(positions)-1 {
-				result += text[positions[i][1]:positions[i+1][0]]
-			}
+		d.tokens[j].text = text
+		d.tokens[j].kind = tokWord
+		if isNumber(text) {
+			d.tokens[j].kind = tokNumber
 		}
+	}
+}
 
-		patternLen := 0
-		if count == 1 {
-			patternLen = len(caseType) + 2 // e.g., "(up)" = 4
-		} else {
-			patternLen = len(fmt.Sprintf("(%s, %d)", caseType, count))
+// mergeBarrier returns the first token between the words at indices that
+// keeps them from being merged into one. Only whitespace and markers may
+// be removed with the words, so "twenty, one" cannot become 21 without
+// losing its comma.
+func (d *document) mergeBarrier(indices []int) (token, bool) {
+	for i := 1; i < len(indices); i++ {
+		for k := indices[i-1] + 1; k < indices[i]; k++ {
+			if kind := d.tokens[k].kind; kind != tokSpace && kind != tokMarker {
+				return d.tokens[k], true
+			}
 		}
-		result += text[positions[len(positions)-1][1]:pos]
-		result += text[pos+patternLen:]
+	}
+	return token{}, false
+}
+
+func isNumericSpan(d *document, s span) bool {
+	return s.end-s.start == 1 && d.tokens[s.start].kind == tokNumber
+}
 
-		return result
+// convertMarker converts a word from one base to another.
+func convertMarker(from, to int) func(call *Call, words []string) ([]string, error) {
+	return func(call *Call, words []string) ([]string, error) {
+		width, err := widthArg(call.Args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return convertBase(call, words[0], from, to, width)
 	}
+}
 
-	if count == 1 {
-		return removePatternAt(text, fmt.Sprintf("(%s)", caseType), pos)
+// baseMarker implements "(base, N)", which converts a word from base N to
+// decimal.
+func baseMarker(call *Call, words []string) ([]string, error) {
+	base, err := strconv.Atoi(call.Args[0])
+	if err != nil || base < 2 || base > 36 {
+		return nil, fmt.Errorf("base %s is not between 2 and 36", call.Args[0])
+	}
+	width, err := widthArg(call.Args, 1)
+	if err != nil {
+		return nil, err
 	}
-	return removePatternAt(text, fmt.Sprintf("(%s, %d)", caseType, count), pos)
+	return convertBase(call, words[0], base, 10, width)
 }
 
-func processNumberedCasePattern(text, pattern string, position int) string {
-	re := regexp.MustCompile(`\(\s*(up|low|cap)\s*,\s*(-?\d+)\s*\)`)
-	matches := re.FindStringSubmatch(pattern)
-	if len(matches) == 3 {
-		caseType := matches[1]
-		count, _ := strconv.Atoi(matches[2])
-
-		if count <= 0 {
-			return removePatternAt(text, pattern, position)
-		}
-
-		words, positions, quotedFlags, quoteChars := findWordsBeforeInLine(text, position, count)
-
-		if len(words) > 0 {
-			transformedWords := make([]string, len(words))
-			for i, word := range words {
-				switch caseType {
-				case "cap":
-					transformedWords[i] = capitalize(word)
-				case "up":
-					transformedWords[i] = strings.ToUpper(word)
-				case "low":
-					transformedWords[i] = strings.ToLower(word)
-				}
-			}
+// maxWidth is the widest two's-complement value "sN" may ask for. It is
+// far beyond any real register, and keeps a typo such as s99999999999 from
+// allocating a number of that many bits.
+const maxWidth = 4096
 
-			result := text
-			for i := len(words) - 1; i >= 0; i-- {
-				wordStart := positions[i][0]
-				wordEnd := positions[i][1]
-				if quotedFlags[i] {
-					if quoteChars[i] == '(' {
-						result = result[:wordStart] + "(" + transformedWords[i] + ")" + result[wordEnd:]
-					} else {
-						quoteChar := quoteChars[i]
-						result = result[:wordStart] + string(quoteChar) + transformedWords[i] + string(quoteChar) + result[wordEnd:]
-					}
-				} else {
-					result = result[:wordStart] + transformedWords[i] + result[wordEnd:]
-				}
-			}
+// widthArg reads the optional "sN" argument at position i, returning 0
+// when it is absent.
+func widthArg(args []string, i int) (int, error) {
+	if i >= len(args) {
+		return 0, nil
+	}
+	digits, ok := strings.CutPrefix(strings.ToLower(args[i]), "s")
+	width, err := strconv.Atoi(digits)
+	if !ok || err != nil || width < 2 || width > maxWidth {
+		return 0, fmt.Errorf("%q is not a signed width from s2 to s%d", args[i], maxWidth)
+	}
+	return width, nil
+}
+
+// convertBase is the conversion engine behind every number marker. Values
+// of any size are converted exactly, and the input may be signed, prefixed,
+// grouped and fractional as parseLiteral allows. A fraction that does not
+// end within the processor's precision is cut off there. Letters are
+// written in upper case, and decimal output is grouped with the processor's
+// thousands separator.
+//
+// A non-zero width reads the input as a two's-complement value of that
+// many bits when converting to decimal, and writes it as one when
+// converting from decimal, so "FFFF (hex, s16)" is -1 and
+// "-1 (tohex, s16)" is FFFF.
+func convertBase(call *Call, word string, from, to, width int) ([]string, error) {
+	value, ok := parseLiteral(word, from, call.thousands)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a base-%d number", word, from)
+	}
 
-			result = removePatternAt(result, pattern, position)
-			return result
+	if width > 0 {
+		if !value.IsInt() {
+			return nil, fmt.Errorf("%q is not a whole number", word)
 		}
+		n := new(big.Int).Set(value.Num())
+		limit := new(big.Int).Lsh(big.NewInt(1), uint(width))
+		half := new(big.Int).Rsh(limit, 1)
+		if to == 10 && n.Cmp(half) >= 0 && n.Cmp(limit) < 0 {
+			n.Sub(n, limit)
+		}
+		if n.Cmp(half) >= 0 || n.Cmp(new(big.Int).Neg(half)) < 0 {
+			return nil, fmt.Errorf("%q does not fit in %d signed bits", word, width)
+		}
+		if to != 10 && n.Sign() < 0 {
+			n.Add(n, limit)
+		}
+		value.SetInt(n)
+	}
+
+	result := formatRadix(value, to, call.precision)
+	if to == 10 {
+		result = groupThousands(result, call.thousands)
 	}
-	return removePatternAt(text, pattern, position)
+	return []string{result}, nil
 }
 
-func findWordsBeforeInLine(text string, patternPos int, count int) ([]string, [][]int, []bool, []byte) {
-	words := []string{}
-	positions := [][]int{}
-	quotedFlags := []bool{}
-	quoteChars := []byte{}
+// literalPrefixes are the prefixes a number may carry in each base.
+var literalPrefixes = map[int]string{16: "0x", 8: "0o", 2: "0b"}
+
+// parseLiteral reads a number written in the given base. It may have a
+// leading '-', a 0x, 0o or 0b prefix matching the base, underscores
+// between digits and a fractional part, as in "-0xDEAD_BEEF" or "1A.8".
+// Decimal numbers may also be grouped with sep.
+func parseLiteral(word string, base int, sep string) (*big.Rat, bool) {
+	digits, negative := strings.CutPrefix(word, "-")
+	if prefix := literalPrefixes[base]; len(digits) > len(prefix) && strings.EqualFold(digits[:len(prefix)], prefix) {
+		digits = digits[len(prefix):]
+	}
+	if base == 10 && sep != "" {
+		digits = strings.ReplaceAll(digits, sep, "")
+	}
 
-	lineStart := strings.LastIndex(text[:patternPos], "\n")
-	if lineStart == -1 {
-		lineStart = 0
-	} else {
-		lineStart++
+	whole, fraction, hasFraction := strings.Cut(digits, ".")
+	whole, ok := cleanDigits(whole, base)
+	if !ok {
+		return nil, false
 	}
+	n, _ := new(big.Int).SetString(whole, base)
+	value := new(big.Rat).SetInt(n)
 
-	lineText := text[lineStart:patternPos]
+	if hasFraction {
+		fraction, ok = cleanDigits(fraction, base)
+		if !ok {
+			return nil, false
+		}
+		n, _ := new(big.Int).SetString(fraction, base)
+		scale := new(big.Int).Exp(big.NewInt(int64(base)), big.NewInt(int64(len(fraction))), nil)
+		value.Add(value, new(big.Rat).SetFrac(n, scale))
+	}
 
-	wordRegex := regexp.MustCompile(`\b[a-zA-Z0-9_]+\b`)
-	matches := wordRegex.FindAllStringSubmatch(lineText, -1)
-	matchIndices := wordRegex.FindAllStringIndex(lineText, -1)
+	if negative {
+		value.Neg(value)
+	}
+	return value, true
+}
 
-	startIdx := len(matches) - count
-	if startIdx < 0 {
-		startIdx = 0
+// cleanDigits removes the underscores from a run of digits in the given
+// base, which may only separate two digits.
+func cleanDigits(digits string, base int) (string, bool) {
+	if strings.HasPrefix(digits, "_") || strings.HasSuffix(digits, "_") || strings.Contains(digits, "__") {
+		return "", false
 	}
+	digits = strings.ReplaceAll(digits, "_", "")
+	return digits, isDigits(digits, base)
+}
 
-	for i := startIdx; i < len(matches); i++ {
-		word := matches[i][0]
-		absoluteStart := lineStart + matchIndices[i][0]
-		absoluteEnd := lineStart + matchIndices[i][1]
+// formatRadix writes value in the given base with at most precision
+// digits after the point.
+func formatRadix(value *big.Rat, base, precision int) string {
+	abs := new(big.Rat).Abs(value)
+	whole := new(big.Int).Quo(abs.Num(), abs.Denom())
+	fraction := abs.Sub(abs, new(big.Rat).SetInt(whole))
+
+	result := strings.ToUpper(whole.Text(base))
+	radix := big.NewRat(int64(base), 1)
+	var digits strings.Builder
+	for i := 0; i < precision && fraction.Sign() != 0; i++ {
+		fraction.Mul(fraction, radix)
+		digit := new(big.Int).Quo(fraction.Num(), fraction.Denom())
+		fraction.Sub(fraction, new(big.Rat).SetInt(digit))
+		digits.WriteString(strings.ToUpper(digit.Text(base)))
+	}
+	if frac := strings.TrimRight(digits.String(), "0"); frac != "" {
+		result += "." + frac
+	}
 
-		words = append(words, word)
-		positions = append(positions, []int{absoluteStart, absoluteEnd})
-		quotedFlags = append(quotedFlags, isQuoted(word))
-		quoteChars = append(quoteChars, getQuoteChar(word))
+	if value.Sign() < 0 && result != "0" {
+		result = "-" + result
 	}
+	return result
+}
+
+// floatMarker decodes a hex bit pattern as an IEEE-754 floating-point
+// number of the given size, 32 or 64 bits.
+func floatMarker(bits int) func(call *Call, words []string) ([]string, error) {
+	return func(call *Call, words []string) ([]string, error) {
+		value, ok := parseLiteral(words[0], 16, "")
+		if !ok || !value.IsInt() || value.Sign() < 0 || value.Num().BitLen() > bits {
+			return nil, fmt.Errorf("%q is not a %d-bit hex pattern", words[0], bits)
+		}
 
-	return words, positions, quotedFlags, quoteChars
+		pattern := value.Num().Uint64()
+		if bits == 32 {
+			return []string{strconv.FormatFloat(float64(math.Float32frombits(uint32(pattern))), 'g', -1, 32)}, nil
+		}
+		return []string{strconv.FormatFloat(math.Float64frombits(pattern), 'g', -1, 64)}, nil
+	}
 }
 
-func normalizeNestedCommandParentheses(text string) string {
-	// Regex to find a word character (a command) followed by one or more
-	// NON-NEWLINE whitespace characters and then an opening parenthesis.
-	// [^\S\n]+ matches one or more whitespace characters that are NOT newlines.
-	re := regexp.MustCompile(`(\w+)[^\S\n]+\(`) // FIX IS HERE
+// groupThousands inserts sep between every three digits of the whole part
+// of a decimal number, counting from the right.
+func groupThousands(digits, sep string) string {
+	if rest, ok := strings.CutPrefix(digits, "-"); ok {
+		return "-" + groupThousands(rest, sep)
+	}
+	if whole, fraction, ok := strings.Cut(digits, "."); ok {
+		return groupThousands(whole, sep) + "." + fraction
+	}
+	if sep == "" || len(digits) <= 3 {
+		return digits
+	}
 
-	// Loop until no more changes can be made. This ensures that we process
-	// all levels of nesting, from the inside out.
-	for {
-		originalText := text
-		text = re.ReplaceAllString(text, "$1(")
-		// If the string is stable (no changes in this iteration), we are done.
-		if text == originalText {
-			break
+	var b strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(sep)
 		}
+		b.WriteRune(c)
 	}
-	return text
+	return b.String()
 }
 
-// normalizeSpaces is the main function you provided, now calling the corrected helper.
-func normalizeSpaces(text string) string {
-	text = formatQuotes(text)
-	// First, normalize nested command parentheses for strict formatting.
-	// This is the function we fixed.
-	text = normalizeNestedCommandParentheses(text)
-	// Then, apply existing general parentheses formatting.
-	text = formatParentheses(text)
-
-	lines := strings.Split(text, "\n")
-	for i, line := range lines {
-		// This regex cleans up extra spaces between regular words.
-		// It runs multiple times per line to ensure all multiple spaces are reduced.
-		// CORRECTED REGEX: Match any non-space tokens, not just words, to handle quoted strings.
-		wordSpaceRegex := regexp.MustCompile(`([^\s]+)\s{2,}([^\s]+)`)
-		for {
-			newLine := wordSpaceRegex.ReplaceAllString(line, "$1 $2")
-			if newLine == line {
-				break
-			}
-			line = newLine
+func caseMarker(caseType string) func(call *Call, words []string) ([]string, error) {
+	return func(call *Call, words []string) ([]string, error) {
+		result := make([]string, len(words))
+		for i, word := range words {
+			result[i] = applyCaseTransformation(word, caseType, call.caseRules)
 		}
-		lines[i] = line
+		return result, nil
 	}
+}
 
-	return strings.Join(lines, "\n")
+func applyCaseTransformation(word, caseType string, rules unicode.SpecialCase) string {
+	switch caseType {
+	case "up":
+		return strings.ToUpperSpecial(rules, word)
+	case "low":
+		return strings.ToLowerSpecial(rules, word)
+	case "cap":
+		return capitalize(word, rules)
+	default:
+		return word
+	}
 }
 
-func formatPunctuation(text string) string {
-	lines := strings.Split(text, "\n")
-	for i, line := range lines {
-		line = normalizeSpaces(line)
-		// CORRECTED REGEX: Capture any non-space character (including quotes) after punctuation.
-		punctRegex := regexp.MustCompile(`\s*([.,!?:;]+)(\s*)([^\s\n]?)`)
-		line = punctRegex.ReplaceAllStringFunc(line, func(match string) string {
-			parts := punctRegex.FindStringSubmatch(match)
-			punct := parts[1]
-			followingChar := parts[3]
-			if followingChar != "" {
-				return punct + " " + followingChar
+// layout drops whitespace tokens and records the whitespace that preceded
+// each remaining token in its space field, so the formatting rules below
+// only have to decide what goes between two tokens. Whitespace at the very
+// end of the text is kept on an empty trailing space token.
+func layout(tokens []token) []token {
+	result := make([]token, 0, len(tokens))
+	pending, pendingPos := "", 0
+
+	for _, tok := range tokens {
+		if tok.kind == tokSpace {
+			if pending == "" {
+				pendingPos = tok.pos
 			}
-			return punct + followingChar
-		})
-
-		ellipsisRegex := regexp.MustCompile(`\s*\.{3,}`)
-		line = ellipsisRegex.ReplaceAllString(line, "...")
-
-		line = handleConsecutivePunctuation(line)
-
-		line = strings.TrimLeft(line, " ")
+			pending += tok.text
+			continue
+		}
+		tok.space = pending
+		pending = ""
+		result = append(result, tok)
+	}
 
-		lines[i] = line
+	if pending != "" {
+		result = append(result, token{kind: tokSpace, space: pending, pos: pendingPos})
 	}
 
-	return strings.Join(lines, "\n")
+	return result
 }
 
-func handleConsecutivePunctuation(text string) string {
+func join(tokens []token) string {
+	var b strings.Builder
+	for _, tok := range tokens {
+		b.WriteString(tok.space)
+		b.WriteString(tok.text)
+	}
+	return b.String()
+}
 
-	punctRegex := regexp.MustCompile(`([.,!?:;])\s+([.,!?:;])`)
-	for {
-		newText := punctRegex.ReplaceAllString(text, "$1$2")
-		if newText == text {
-			break
+// normalizeSpaces collapses runs of whitespace to a single space and strips
+// whitespace at the start and end of every line.
+func normalizeSpaces(tokens []token) {
+	for i := range tokens {
+		switch {
+		case i == 0 || tokens[i-1].kind == tokNewline:
+			tokens[i].space = ""
+		case tokens[i].kind == tokNewline || tokens[i].kind == tokSpace:
+			tokens[i].space = ""
+		case tokens[i].space != "":
+			tokens[i].space = " "
 		}
-		text = newText
 	}
-	return text
 }
 
-func formatQuotes(text string) string {
-	lines := strings.Split(text, "\n")
-	for i, line := range lines {
-		line = formatQuotesInLine(line)
-		lines[i] = line
+// setSpace sets the gap before token i unless i starts a line.
+func setSpace(tokens []token, i int, space string) {
+	if i <= 0 || i >= len(tokens) || tokens[i-1].kind == tokNewline {
+		return
+	}
+	if tokens[i].kind == tokNewline || tokens[i].kind == tokSpace {
+		return
 	}
-	return strings.Join(lines, "\n")
+	tokens[i].space = space
 }
 
-func formatQuotesInLine(line string) string {
-	line = handleConsecutiveQuotes(line, '\'')
-	line = handleConsecutiveQuotes(line, '"')
-	line = formatQuoteType(line, '\'')
-	line = formatQuoteType(line, '"')
+// formatQuotes pulls paired quotes tight against the text they enclose:
+// ' awesome ' becomes 'awesome'. An opening quote glued to the previous
+// word and a closing quote glued to the next one get a space.
+func formatQuotes(tokens []token) {
+	pairs := matchPairs(tokens)
+	for i, tok := range tokens {
+		if tok.kind != tokQuote || pairs[i] < 0 {
+			continue
+		}
 
-	return line
+		if pairs[i] > i {
+			setSpace(tokens, i+1, "")
+			if i > 0 && tokens[i-1].isWord() && tok.space == "" {
+				setSpace(tokens, i, " ")
+			}
+		} else {
+			setSpace(tokens, i, "")
+			if i+1 < len(tokens) && tokens[i+1].isWord() {
+				setSpace(tokens, i+1, " ")
+			}
+		}
+	}
 }
 
-func handleConsecutiveQuotes(line string, quoteChar rune) string {
-	quoteStr := string(quoteChar)
-	pattern := regexp.QuoteMeta(quoteStr) + `{3,}`
-	consecutiveRegex := regexp.MustCompile(pattern)
-
-	return consecutiveRegex.ReplaceAllStringFunc(line, func(match string) string {
-		count := len(match)
-		pairs := count / 2
-		remainder := count % 2
-
-		result := strings.Repeat(quoteStr+quoteStr+" ", pairs)
-		if remainder > 0 {
-			result += quoteStr
-		} else if len(result) > 0 {
-			result = result[:len(result)-1]
+// formatParentheses removes whitespace just inside parentheses.
+func formatParentheses(tokens []token) {
+	for i, tok := range tokens {
+		if tok.kind != tokSymbol {
+			continue
 		}
-		return result
-	})
+		switch tok.text {
+		case "(":
+			setSpace(tokens, i+1, "")
+		case ")":
+			setSpace(tokens, i, "")
+		}
+	}
 }
 
-func formatParentheses(text string) string {
-	parenRegex := regexp.MustCompile(`\(\s*([^()]*?)\s*\)`)
-	text = parenRegex.ReplaceAllStringFunc(text, func(match string) string {
-		content := strings.Trim(match, "()")
-		content = strings.TrimSpace(content)
-
-		content = regexp.MustCompile(`\s+`).ReplaceAllString(content, " ")
-		return "(" + content + ")"
-	})
-
-	return text
-}
+var ellipsisRegex = regexp.MustCompile(`\.{3,}`)
 
-func fixArticles(text string) string {
-	lines := strings.Split(text, "\n")
-
-	for i, line := range lines {
-		sequenceRegex := regexp.MustCompile(`(\b(?:[aA]n?\s+)*)([aA]n?)\s+([a-zA-Z]\w*)`)
-
-		line = sequenceRegex.ReplaceAllStringFunc(line, func(match string) string {
-			parts := sequenceRegex.FindStringSubmatch(match)
-			if len(parts) >= 4 {
-				precedingArticles := parts[1]
-				lastArticle := parts[2]
-				targetWord := parts[3]
-				lowerTargetWord := strings.ToLower(targetWord)
-				if lowerTargetWord == "a" || lowerTargetWord == "an" {
-					return match
-				}
+// formatPunctuation attaches punctuation to the previous word and separates
+// it from the next one with a single space. Consecutive marks stay grouped
+// ("...", "!?") and long runs of dots are shortened to an ellipsis.
+func formatPunctuation(tokens []token) []token {
+	pairs := matchPairs(tokens)
+	for i, tok := range tokens {
+		if tok.kind != tokPunct {
+			continue
+		}
+		setSpace(tokens, i, "")
 
-				firstChar := strings.ToLower(string(targetWord[0]))
-				needsAn := firstChar == "a" || firstChar == "e" || firstChar == "i" ||
-					firstChar == "o" || firstChar == "u" || firstChar == "h"
-
-				var newLastArticle string
-				if needsAn {
-					if lastArticle == "A" {
-						newLastArticle = "An"
-					} else if lastArticle == "a" {
-						newLastArticle = "an"
-					} else {
-						newLastArticle = lastArticle
-					}
-				} else {
-					if lastArticle == "A" || lastArticle == "An" {
-						newLastArticle = "A"
-					} else {
-						newLastArticle = "a"
-					}
-				}
+		if i+1 >= len(tokens) {
+			continue
+		}
+		next := tokens[i+1]
+		switch {
+		case next.isWord(), next.kind == tokQuote && pairs[i+1] > i+1, next.kind == tokSymbol && next.text == "(":
+			setSpace(tokens, i+1, " ")
+		}
+	}
 
-				return precedingArticles + newLastArticle + " " + targetWord
-			}
-			return match
-		})
+	result := make([]token, 0, len(tokens))
+	for _, tok := range tokens {
+		if n := len(result); n > 0 && tok.kind == tokPunct && result[n-1].kind == tokPunct && tok.space == "" {
+			result[n-1].text += tok.text
+			continue
+		}
+		result = append(result, tok)
+	}
 
-		lines[i] = line
+	for i := range result {
+		if result[i].kind == tokPunct {
+			result[i].text = ellipsisRegex.ReplaceAllString(result[i].text, "...")
+		}
 	}
 
-	return strings.Join(lines, "\n")
+	return result
 }
 
-func formatQuoteType(line string, quoteChar rune) string {
-	runes := []rune(line)
-	result := make([]rune, 0, len(runes))
-
-	i := 0
-	for i < len(runes) {
-		if runes[i] == quoteChar {
-			if i > 0 && unicode.IsLetter(runes[i-1]) {
-				prevChar := unicode.ToLower(runes[i-1])
-				if prevChar != 'n' && prevChar != 't' {
-					if len(result) > 0 && result[len(result)-1] != ' ' {
-						result = append(result, ' ')
-					}
-				}
-			}
-
-			i++
-
-			closeQuotePos := -1
-			for j := i; j < len(runes); j++ {
-				if runes[j] == quoteChar {
-					closeQuotePos = j
-					break
-				}
-			}
-
-			if closeQuotePos == -1 {
-				result = append(result, quoteChar)
-				result = append(result, runes[i:len(runes)]...)
-				result = append(result, quoteChar)
-				break
-			} else {
-				content := runes[i:closeQuotePos]
+// fixArticles turns "a" into "an" before a word for which needsAn holds
+// (by default one starting with a vowel or 'h'), and "an" into "a" before
+// anything else. A following article is left alone so that "a a a" stays
+// as written.
+func fixArticles(tokens []token, needsAn ArticleRule) {
+	pairs := matchPairs(tokens)
+	for i, tok := range tokens {
+		lower := strings.ToLower(tok.text)
+		if tok.kind != tokWord || (lower != "a" && lower != "an") {
+			continue
+		}
 
-				contentStr := strings.TrimSpace(string(content))
+		j := i + 1
+		if j < len(tokens) && tokens[j].space == "" {
+			continue
+		}
+		if j < len(tokens) && tokens[j].kind == tokQuote && pairs[j] > j {
+			j++
+		}
+		if j >= len(tokens) || tokens[j].kind != tokWord {
+			continue
+		}
 
-				result = append(result, quoteChar)
-				result = append(result, []rune(contentStr)...)
-				result = append(result, quoteChar)
+		next := strings.ToLower(tokens[j].text)
+		if next == "" || next == "a" || next == "an" || !unicode.IsLetter([]rune(next)[0]) {
+			continue
+		}
 
-				nextPos := closeQuotePos + 1
-				if nextPos < len(runes) && isWordChar(byte(runes[nextPos])) {
-					result = append(result, ' ')
-				}
+		tokens[i].text = article(tok.text, needsAn(tokens[j].text))
+	}
+}
 
-				i = nextPos
-			}
-		} else {
-			result = append(result, runes[i])
-			i++
+func article(current string, needsAn bool) string {
+	if !needsAn {
+		if current[0] == 'A' {
+			return "A"
 		}
+		return "a"
 	}
 
-	return string(result)
+	switch current {
+	case "a":
+		return "an"
+	case "A":
+		return "An"
+	}
+	return current
 }
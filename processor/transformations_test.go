@@ -0,0 +1,274 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+)
+
+// runCases runs every input through p and compares the text it produces.
+func runCases(t *testing.T, p *Processor, tests []struct{ in, want string }) {
+	t.Helper()
+	for _, tt := range tests {
+		if got := p.Process(tt.in); got != tt.want {
+			t.Errorf("Process(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// runInvalid checks that each input is left as it was up to its marker and
+// draws one CodeInvalidTarget diagnostic whose message ends in message.
+func runInvalid(t *testing.T, p *Processor, tests []struct{ in, message string }) {
+	t.Helper()
+	for _, tt := range tests {
+		r := p.Run(tt.in)
+		if want := tt.in[:strings.Index(tt.in, " (")]; r.Text != want {
+			t.Errorf("Run(%q).Text = %q, want %q", tt.in, r.Text, want)
+		}
+		if len(r.Diagnostics) != 1 || r.Diagnostics[0].Code != CodeInvalidTarget || !strings.HasSuffix(r.Diagnostics[0].Message, tt.message) {
+			t.Errorf("Run(%q) diagnostics = %v, want one %s ending in %s", tt.in, r.Diagnostics, CodeInvalidTarget, tt.message)
+		}
+	}
+}
+
+func TestCaseMarkers(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"Ready, set, go (up) !", "Ready, set, GO!"},
+		{"I should stop SHOUTING (low)", "I should stop shouting"},
+		{"Welcome to the Brooklyn bridge (cap)", "Welcome to the Brooklyn Bridge"},
+		{"This is so exciting (up, 2)", "This is SO EXCITING"},
+		{"it was THE BEST OF TIMES (low, 4)", "it was the best of times"},
+		{"harold wilson (cap, 2) spoke", "Harold Wilson spoke"},
+		{"one (up) two (up) three (cap, 3)", "One Two Three"},
+		{"hello (cap(low))", "Hello"},
+		{"done (up, 0)", "done"},
+		{"one two (UP, 2)", "ONE TWO"},
+		{"it (up)\n(low) next", "IT\nnext"},
+	})
+}
+
+func TestNumberMarkers(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"1E (hex) files were added", "30 files were added"},
+		{"It has been 10 (bin) years", "It has been 2 years"},
+		{"ff (hex)", "255"},
+		{"a(hex)(bin)", "2"},
+		{"zz (hex)", "zz"},
+		{"12 (bin)", "12"},
+	})
+}
+
+func TestFormatting(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"I was sitting over there ,and then BAMM !!", "I was sitting over there, and then BAMM!!"},
+		{"I was thinking ... You were right", "I was thinking... You were right"},
+		{"Punctuation tests are ... kinda boring ,what do you think ?", "Punctuation tests are... kinda boring, what do you think?"},
+		{"I am exactly how they describe me: ' awesome '", "I am exactly how they describe me: 'awesome'"},
+		{"As Elton John said: ' I am the most well-known homosexual in the world '", "As Elton John said: 'I am the most well-known homosexual in the world'"},
+		{"There it was. A amazing rock!", "There it was. An amazing rock!"},
+		{"an book and a hour", "a book and an hour"},
+		{"a a a", "a a a"},
+		{"  lots   of   space  ", "lots of space"},
+		{"wait.......", "wait..."},
+		{"( inside )", "(inside)"},
+		{"line one  \n  line two", "line one\nline two"},
+	})
+}
+
+func TestForwardMarkers(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"(up>) next word", "NEXT word"},
+		{"(>cap) word", "Word"},
+		{"(up>, 3) next three words here", "NEXT THREE WORDS here"},
+		{"(>up, 2) a b c", "A B c"},
+		{"(cap>) 'quoted words' after", "'Quoted Words' after"},
+		{"(low>, 2) (ONE TWO) THREE", "(one two) THREE"},
+		{"(hex>) ff", "255"},
+		{"(up>, 2) one\ntwo", "ONE\ntwo"},
+		{"(up>)", ""},
+	})
+}
+
+func TestForwardMarkerDiagnostics(t *testing.T) {
+	tests := []struct{ in, code string }{
+		{"(up>, 2) one\ntwo", CodeBadCount},
+		{"(up>)", CodeNoTarget},
+	}
+	for _, tt := range tests {
+		if r := Run(tt.in); len(r.Diagnostics) != 1 || r.Diagnostics[0].Code != tt.code {
+			t.Errorf("Run(%q) diagnostics = %v, want one %s", tt.in, r.Diagnostics, tt.code)
+		}
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"(up:begin) it was (low:begin) QUIET (low:end) here (up:end)", "IT WAS quiet HERE"},
+		{"(hex:begin) 1E FF 10 (hex:end)", "30 255 16"},
+		{"(up:begin) one two\n\nthree (up:end) four", "ONE TWO\n\nTHREE four"},
+		{"(cap:begin) 'quoted words' (up) and more (cap:end)", "'QUOTED WORDS' And More"},
+		{"(up:begin) open", "open"},
+		{"close (up:end)", "close"},
+		{"(up:begin) a (low:end) b (up:end)", "A B"},
+	})
+}
+
+func TestBlockDiagnostics(t *testing.T) {
+	tests := []struct{ in, code string }{
+		{"(up:begin) open", CodeUnmatchedBlock},
+		{"close (up:end)", CodeUnmatchedBlock},
+		{"(hex:begin) ff zz (hex:end)", CodeInvalidTarget},
+	}
+	for _, tt := range tests {
+		if r := Run(tt.in); len(r.Diagnostics) != 1 || r.Diagnostics[0].Code != tt.code {
+			t.Errorf("Run(%q) diagnostics = %v, want one %s", tt.in, r.Diagnostics, tt.code)
+		}
+	}
+}
+
+func TestChains(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"hello (up|cap)", "Hello"},
+		{"hello (up + low)", "hello"},
+		{"two words (low|cap, 2)", "Two Words"},
+		{"ff (hex|up)", "255"},
+		{"10 (bin|hex)", "2"},
+		{"word (up|nope)", "word (up|nope)"},
+	})
+	if r := Run("word (up|nope)"); len(r.Diagnostics) != 1 || r.Diagnostics[0].Code != CodeUnknownMarker {
+		t.Errorf("got %v, want one %s diagnostic", r.Diagnostics, CodeUnknownMarker)
+	}
+}
+
+func TestBaseMarkers(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"17 (oct)", "15"},
+		{"zz (base, 36)", "1295"},
+		{"101 (base, 2)", "5"},
+		{"255 (tohex)", "FF"},
+		{"5 (tobin)", "101"},
+		{"8 (tooct)", "10"},
+		{"0 (tohex)", "0"},
+		{"-1 (tobin)", "-1"},
+		{"It was 1E (hex).", "It was 30."},
+		{"read 10 (tobin), then", "read 1010, then"},
+	})
+}
+
+func TestInvalidNumbers(t *testing.T) {
+	runInvalid(t, New(), []struct{ in, message string }{
+		{"18 (oct)", `"18" is not a base-8 number`},
+		{"9 (base, 8)", `"9" is not a base-8 number`},
+		{"ff (base, 37)", "base 37 is not between 2 and 36"},
+		{"ff (base, 1)", "base 1 is not between 2 and 36"},
+		{"ff (tohex)", `"ff" is not a base-10 number`},
+		{"2 (bin)", `"2" is not a base-2 number`},
+	})
+}
+
+func TestBigNumbers(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"FFFFFFFFFFFFFFFF (hex)", "18446744073709551615"},
+		{"FFFFFFFFFFFFFFFFFFFF (hex)", "1208925819614629174706175"},
+		{"1" + strings.Repeat("0", 64) + " (bin)", "18446744073709551616"},
+		{"99999999999999999999 (tohex)", "56BC75E2D630FFFFF"},
+	})
+}
+
+func TestThousandsSeparator(t *testing.T) {
+	runCases(t, New(WithThousandsSeparator(",")), []struct{ in, want string }{
+		{"FFFFFF (hex)", "16,777,215"},
+		{"FF (hex)", "255"},
+		{"-F4240 (hex)", "-1,000,000"},
+		{"1,234 (tohex)", "4D2"},
+		{"1234 (tobin)", "10011010010"},
+	})
+	runCases(t, New(WithThousandsSeparator(" ")), []struct{ in, want string }{
+		{"F4240.8 (hex)", "1 000 000.5"},
+	})
+}
+
+func TestGroupThousands(t *testing.T) {
+	tests := []struct{ digits, sep, want string }{
+		{"1234567", ",", "1,234,567"},
+		{"123", ",", "123"},
+		{"1234", "", "1234"},
+		{"-1234.5678", ",", "-1,234.5678"},
+		{"123456", "'", "123'456"},
+	}
+	for _, tt := range tests {
+		if got := groupThousands(tt.digits, tt.sep); got != tt.want {
+			t.Errorf("groupThousands(%q, %q) = %q, want %q", tt.digits, tt.sep, got, tt.want)
+		}
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"0x1E (hex)", "30"},
+		{"0X1e (hex)", "30"},
+		{"0b1010 (bin)", "10"},
+		{"0o17 (oct)", "15"},
+		{"-FF (hex)", "-255"},
+		{"1_000 (bin)", "8"},
+		{"DEAD_BEEF (hex)", "3735928559"},
+		{"0x (hex)", "0x"},
+		{"0b12 (bin)", "0b12"},
+		{"_1 (bin)", "_1"},
+		{"1_ (bin)", "1_"},
+		{"1__0 (bin)", "1__0"},
+	})
+}
+
+func TestSignedWidth(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"FFFF (hex, s16)", "-1"},
+		{"7FFF (hex, s16)", "32767"},
+		{"8000 (hex, s16)", "-32768"},
+		{"-1 (tohex, s16)", "FFFF"},
+		{"-1 (tohex, s4096)", strings.Repeat("F", 1024)},
+		{"1FFFF (hex, s16)", "1FFFF"},
+		{"32768 (tohex, s16)", "32768"},
+		{"1.5 (tohex, s8)", "1.5"},
+	})
+}
+
+func TestBadWidths(t *testing.T) {
+	runInvalid(t, New(), []struct{ in, message string }{
+		{"FF (hex, 16)", `"16" is not a signed width from s2 to s4096`},
+		{"FF (hex, s1)", `"s1" is not a signed width from s2 to s4096`},
+		{"FF (hex, s4097)", `"s4097" is not a signed width from s2 to s4096`},
+		{"0 (hex, s99999999999)", `"s99999999999" is not a signed width from s2 to s4096`},
+		{"1 (hex, s9223372036854775807)", `"s9223372036854775807" is not a signed width from s2 to s4096`},
+		{"1 (tohex, s99999999999999999999)", `"s99999999999999999999" is not a signed width from s2 to s4096`},
+	})
+}
+
+func TestFractions(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"1A.8 (hex)", "26.5"},
+		{"1A.F (hex)", "26.9375"},
+		{"0xff.ff (hex)", "255.99609375"},
+		{"-0x1A.8 (hex)", "-26.5"},
+		{"101.01 (bin)", "5.25"},
+		{"7.4 (oct)", "7.5"},
+		{"26.5 (tohex)", "1A.8"},
+		{"0.5 (tooct)", "0.4"},
+		{"0.1 (tobin)", "0.000110011"},
+		{"1A.G (hex)", "1A. G"},
+	})
+	runCases(t, New(WithPrecision(3)), []struct{ in, want string }{
+		{"0.1 (tobin)", "0"},
+		{"0.75 (tobin)", "0.11"},
+	})
+}
+
+func TestFloatMarkers(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"3F800000 (f32hex)", "1"},
+		{"40490FDB (f32hex)", "3.1415927"},
+		{"7F800000 (f32hex)", "+Inf"},
+		{"400921FB54442D18 (f64hex)", "3.141592653589793"},
+		{"1FFFFFFFF (f32hex)", "1FFFFFFFF"},
+		{"-1 (f32hex)", "-1"},
+	})
+}
@@ -0,0 +1,26 @@
+package processor
+
+import "testing"
+
+func TestIsNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"42", true},
+		{"-42", true},
+		{"3.14", true},
+		{"1,000", true},
+		{"", false},
+		{"-", false},
+		{".", false},
+		{",", false},
+		{"-.,", false},
+		{"4a", false},
+	}
+	for _, tt := range tests {
+		if got := isNumber(tt.in); got != tt.want {
+			t.Errorf("isNumber(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package processor
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var smallNumberWords = []string{
+	"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+	"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen",
+	"seventeen", "eighteen", "nineteen",
+}
+
+var tensWords = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+
+var scaleWords = []string{"", "thousand", "million", "billion", "trillion", "quadrillion", "quintillion"}
+
+var irregularOrdinals = map[string]string{
+	"one": "first", "two": "second", "three": "third", "five": "fifth",
+	"eight": "eighth", "nine": "ninth", "twelve": "twelfth",
+}
+
+// numberWord is the meaning of one number word.
+type numberWord struct {
+	value   int64
+	ordinal bool
+}
+
+// numberWords maps every cardinal and ordinal number word to its value and
+// whether it is an ordinal. "hundred" and the scale words are multipliers.
+var numberWords = func() map[string]numberWord {
+	words := map[string]numberWord{}
+	add := func(word string, value int64) {
+		words[word] = numberWord{value, false}
+		words[ordinalWord(word)] = numberWord{value, true}
+	}
+
+	for i, w := range smallNumberWords {
+		add(w, int64(i))
+	}
+	for i, w := range tensWords[2:] {
+		add(w, int64(i+2)*10)
+	}
+	add("hundred", 100)
+	scale := int64(1)
+	for _, w := range scaleWords[1:] {
+		scale *= 1000
+		add(w, scale)
+	}
+	return words
+}()
+
+// wordsMarker implements "(words)": 42 becomes "forty-two", -3.5 becomes
+// "minus three point five".
+func wordsMarker(call *Call, words []string) ([]string, error) {
+	digits := words[0]
+	if call.thousands != "" {
+		digits = strings.ReplaceAll(digits, call.thousands, "")
+	}
+	digits, negative := strings.CutPrefix(digits, "-")
+	whole, fraction, hasFraction := strings.Cut(digits, ".")
+	if !isDigits(whole, 10) || (hasFraction && !isDigits(fraction, 10)) {
+		return nil, fmt.Errorf("%q is not a number", words[0])
+	}
+
+	n, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s is too large to spell out", words[0])
+	}
+
+	result := spellNumber(n)
+	if hasFraction {
+		result += " point"
+		for _, d := range fraction {
+			result += " " + smallNumberWords[d-'0']
+		}
+	}
+	if negative {
+		result = "minus " + result
+	}
+	return []string{result}, nil
+}
+
+// ordinalMarker implements "(ordinal)": 42 becomes "42nd", and a spelled
+// number such as "forty-two" becomes "forty-second".
+func ordinalMarker(call *Call, words []string) ([]string, error) {
+	word := words[0]
+	digits := strings.TrimPrefix(word, "-")
+	if call.thousands != "" {
+		digits = strings.ReplaceAll(digits, call.thousands, "")
+	}
+	if isDigits(digits, 10) {
+		return []string{word + ordinalSuffix(digits)}, nil
+	}
+
+	// Only the last part of a spelled number changes: "one hundred five"
+	// becomes "one hundred fifth".
+	cut := strings.LastIndexAny(word, " -") + 1
+	last := strings.ToLower(word[cut:])
+	if entry, ok := numberWords[last]; !ok || entry.ordinal {
+		return nil, fmt.Errorf("%q is not a number", word)
+	}
+
+	ordinal := ordinalWord(last)
+	if r := []rune(word[cut:]); unicode.IsUpper(r[0]) {
+		ordinal = call.Capitalize(ordinal)
+	}
+	return []string{word[:cut] + ordinal}, nil
+}
+
+// numMarker implements "(num)" and "(num, N)": a number spelled out over
+// one or more words, such as "one hundred and five", becomes digits.
+// Spelled ordinals become numeric ones, so "forty-second" gives "42nd".
+func numMarker(call *Call, words []string) ([]string, error) {
+	text := strings.ToLower(strings.Join(words, " "))
+	fields := strings.FieldsFunc(text, func(r rune) bool { return r == ' ' || r == '-' })
+
+	total, current := new(big.Int), new(big.Int)
+	negative, ordinal := false, false
+	fraction := ""
+	// previous is the last word below a hundred since the last multiplier.
+	// Only a ten may be followed by another such word, as in "forty two".
+	var previous string
+	for i, field := range fields {
+		if ordinal {
+			return nil, fmt.Errorf("%q does not end the number", fields[i-1])
+		}
+		if fraction != "" || field == "point" {
+			if field == "point" && fraction == "" {
+				fraction = "."
+				continue
+			}
+			entry, ok := numberWords[field]
+			if !ok || entry.ordinal || entry.value > 9 {
+				return nil, fmt.Errorf("%q is not a digit after \"point\"", field)
+			}
+			fraction += strconv.FormatInt(entry.value, 10)
+			continue
+		}
+
+		entry, ok := numberWords[field]
+		switch {
+		case i == 0 && (field == "minus" || field == "negative"):
+			negative = true
+		case field == "and" && i > 0:
+		case !ok:
+			return nil, fmt.Errorf("%q is not a number word", field)
+		case entry.value == 100:
+			if current.Sign() == 0 {
+				current.SetInt64(1)
+			}
+			current.Mul(current, big.NewInt(100))
+			previous = ""
+		case entry.value >= 1000:
+			if current.Sign() == 0 {
+				current.SetInt64(1)
+			}
+			total.Add(total, current.Mul(current, big.NewInt(entry.value)))
+			current = new(big.Int)
+			previous = ""
+		default:
+			if previous != "" && !(isTens(numberWords[previous].value) && entry.value >= 1 && entry.value <= 9) {
+				return nil, fmt.Errorf("%q cannot follow %q", field, previous)
+			}
+			current.Add(current, big.NewInt(entry.value))
+			previous = field
+		}
+		ordinal = ok && entry.ordinal
+	}
+	if fraction == "." {
+		return nil, fmt.Errorf("no digits follow \"point\"")
+	}
+	if total.Sign() == 0 && current.Sign() == 0 && !strings.Contains(text, "zero") {
+		return nil, fmt.Errorf("%q is not a number", strings.Join(words, " "))
+	}
+
+	digits := total.Add(total, current).String()
+	result := groupThousands(digits, call.thousands) + fraction
+	if ordinal {
+		result += ordinalSuffix(digits)
+	}
+	if negative {
+		result = "-" + result
+	}
+	return []string{result}, nil
+}
+
+// isTens reports whether n is one of twenty, thirty, ... ninety.
+func isTens(n int64) bool {
+	return n >= 20 && n < 100 && n%10 == 0
+}
+
+// spellNumber writes n in English words, without "and".
+func spellNumber(n uint64) string {
+	if n == 0 {
+		return "zero"
+	}
+
+	var groups []string
+	for scale := 0; n > 0; scale++ {
+		chunk := int(n % 1000)
+		n /= 1000
+		if chunk == 0 {
+			continue
+		}
+		group := spellHundreds(chunk)
+		if scale > 0 {
+			group += " " + scaleWords[scale]
+		}
+		groups = append([]string{group}, groups...)
+	}
+	return strings.Join(groups, " ")
+}
+
+func spellHundreds(n int) string {
+	var parts []string
+	if n >= 100 {
+		parts = append(parts, smallNumberWords[n/100]+" hundred")
+		n %= 100
+	}
+	switch {
+	case n >= 20 && n%10 != 0:
+		parts = append(parts, tensWords[n/10]+"-"+smallNumberWords[n%10])
+	case n >= 20:
+		parts = append(parts, tensWords[n/10])
+	case n > 0:
+		parts = append(parts, smallNumberWords[n])
+	}
+	return strings.Join(parts, " ")
+}
+
+// ordinalWord turns a cardinal number word into an ordinal one: "two"
+// becomes "second", "forty" becomes "fortieth".
+func ordinalWord(word string) string {
+	if ordinal, ok := irregularOrdinals[word]; ok {
+		return ordinal
+	}
+	if stem, ok := strings.CutSuffix(word, "y"); ok {
+		return stem + "ieth"
+	}
+	return word + "th"
+}
+
+// ordinalSuffix returns "st", "nd", "rd" or "th" for a run of digits.
+func ordinalSuffix(digits string) string {
+	if len(digits) >= 2 && digits[len(digits)-2] == '1' {
+		return "th"
+	}
+	switch digits[len(digits)-1] {
+	case '1':
+		return "st"
+	case '2':
+		return "nd"
+	case '3':
+		return "rd"
+	}
+	return "th"
+}
@@ -0,0 +1,72 @@
+package processor
+
+import "testing"
+
+func TestWordsMarker(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"42 (words)", "forty-two"},
+		{"-3.5 (words)", "minus three point five"},
+		{"0 (words)", "zero"},
+		{"1000001 (words)", "one million one"},
+		{"18446744073709551616 (words)", "18446744073709551616"},
+		{"abc (words)", "abc"},
+	})
+	runCases(t, New(WithThousandsSeparator(",")), []struct{ in, want string }{
+		{"1,000 (words)", "one thousand"},
+	})
+}
+
+func TestOrdinalMarker(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"42 (ordinal)", "42nd"},
+		{"11 (ordinal)", "11th"},
+		{"112 (ordinal)", "112th"},
+		{"21 (ordinal)", "21st"},
+		{"twelve (ordinal)", "twelfth"},
+		{"Forty-Two (ordinal)", "Forty-Second"},
+		{"second (ordinal)", "second"},
+		{"apple (ordinal)", "apple"},
+	})
+}
+
+func TestNumMarker(t *testing.T) {
+	runCases(t, New(), []struct{ in, want string }{
+		{"twenty one (num, 2)", "21"},
+		{"twenty-first (num)", "21st"},
+		{"forty second (num, 2)", "42nd"},
+		{"one hundred and five (num, 4)", "105"},
+		{"one hundred one (num, 3)", "101"},
+		{"two thousand twenty four (num, 4)", "2024"},
+		{"nineteen hundred (num, 2)", "1900"},
+		{"three hundred forty-two thousand (num, 4)", "342000"},
+		{"zero (num)", "0"},
+		{"minus three point one four (num, 5)", "-3.14"},
+		{"one hundred (num, 2) (ordinal)", "100th"},
+	})
+}
+
+func TestInvalidNumberWords(t *testing.T) {
+	runInvalid(t, New(), []struct{ in, message string }{
+		{"one one (num, 2)", `"one" cannot follow "one"`},
+		{"one and one (num, 3)", `"one" cannot follow "one"`},
+		{"eleven one (num, 2)", `"one" cannot follow "eleven"`},
+		{"five twenty (num, 2)", `"twenty" cannot follow "five"`},
+		{"twenty thirty (num, 2)", `"thirty" cannot follow "twenty"`},
+		{"twenty zero (num, 2)", `"zero" cannot follow "twenty"`},
+		{"first second (num, 2)", `"first" does not end the number`},
+		{"three point (num, 2)", `no digits follow "point"`},
+		{"one apple (num, 2)", `"apple" is not a number word`},
+	})
+}
+
+func TestMergeStopsAtPunctuation(t *testing.T) {
+	runInvalid(t, New(), []struct{ in, message string }{
+		{"twenty, one (num, 2)", `cannot join words separated by ","`},
+		{`"one" hundred (num, 2)`, `cannot join words separated by "\""`},
+		{"twenty one, thousand (num, 3)", `cannot join words separated by ","`},
+	})
+
+	runCases(t, New(), []struct{ in, want string }{
+		{"twenty (up) one (num, 2)", "21"},
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-reloaded/processor"
+	"os"
+)
+
+// fileReport is the part of a --report json document for one input.
+type fileReport struct {
+	File  string           `json:"file"`
+	Edits []processor.Edit `json:"edits"`
+}
+
+// writeReport prints every change processing would make to the inputs as
+// JSON instead of writing anything. It reports whether all inputs could be
+// processed.
+func writeReport(proc *processor.Processor, inputs []string) bool {
+	ok := true
+	reports := []fileReport{}
+	for _, input := range inputs {
+		content, err := readInput(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
+			ok = false
+			continue
+		}
+
+		result := proc.Run(content)
+		for _, d := range result.Diagnostics {
+			printDiagnostic(displayName(input), d)
+		}
+		if result.Err() != nil {
+			ok = false
+			continue
+		}
+
+		edits := result.Edits
+		if edits == nil {
+			edits = []processor.Edit{}
+		}
+		reports = append(reports, fileReport{File: displayName(input), Edits: edits})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(reports); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"go-reloaded/processor"
+	"strings"
+	"testing"
+)
+
+func TestWriteReport(t *testing.T) {
+	dir := t.TempDir()
+	clean := writeFile(t, dir, "clean.txt", "Fine.\n")
+	dirty := writeFile(t, dir, "dirty.txt", "1E (hex) <files>\n")
+
+	var ok bool
+	out := captureStdout(t, func() { ok = writeReport(processor.New(), []string{clean, dirty}) })
+	if !ok {
+		t.Error("writeReport failed")
+	}
+
+	if strings.Contains(out, `\u003c`) {
+		t.Errorf("HTML characters are escaped in %s", out)
+	}
+
+	var reports []fileReport
+	if err := json.Unmarshal([]byte(out), &reports); err != nil {
+		t.Fatalf("%v in %s", err, out)
+	}
+	if len(reports) != 2 || reports[0].File != clean || reports[1].File != dirty {
+		t.Fatalf("got %+v, want a report for each file", reports)
+	}
+	if reports[0].Edits == nil || len(reports[0].Edits) != 0 {
+		t.Errorf("clean file: got %+v, want an empty list", reports[0].Edits)
+	}
+	want := processor.Edit{Rule: "hex", Original: "1E", Replacement: "30", Offset: 0, Line: 1, Column: 1}
+	if len(reports[1].Edits) == 0 || reports[1].Edits[0] != want {
+		t.Errorf("dirty file: got %+v, want %+v first", reports[1].Edits, want)
+	}
+}
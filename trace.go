@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"go-reloaded/processor"
+	"os"
+	"strings"
+)
+
+// showTraces prints, for every input, what each marker did and the text
+// after each stage that changed it, instead of writing anything. proc must
+// have been built with processor.WithTrace. It reports whether all inputs
+// could be processed.
+func showTraces(proc *processor.Processor, inputs []string) bool {
+	ok := true
+	for _, input := range inputs {
+		content, err := readInput(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
+			ok = false
+			continue
+		}
+
+		result := proc.Run(content)
+		for _, d := range result.Diagnostics {
+			printDiagnostic(displayName(input), d)
+		}
+		if result.Err() != nil {
+			ok = false
+		}
+
+		fmt.Printf("=== %s\n", displayName(input))
+		for _, step := range result.Trace.Steps {
+			fmt.Printf("%s:%d:%d: %s %s\n", displayName(input), step.Line, step.Column, step.Marker, describeEdits(step.Edits))
+		}
+
+		previous := content
+		for _, stage := range result.Trace.Stages {
+			if stage.Text == previous {
+				continue
+			}
+			fmt.Printf("--- after %s\n", stage.Rule)
+			fmt.Print(stage.Text)
+			if !strings.HasSuffix(stage.Text, "\n") {
+				fmt.Println()
+			}
+			previous = stage.Text
+		}
+	}
+	return ok
+}
+
+// describeEdits summarizes the words a marker changed.
+func describeEdits(edits []processor.Edit) string {
+	if len(edits) == 0 {
+		return "changed nothing"
+	}
+	parts := make([]string, len(edits))
+	for i, e := range edits {
+		parts[i] = fmt.Sprintf("%q -> %q", e.Original, e.Replacement)
+	}
+	return strings.Join(parts, ", ")
+}
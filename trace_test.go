@@ -0,0 +1,23 @@
+package main
+
+import (
+	"go-reloaded/processor"
+	"testing"
+)
+
+func TestShowTraces(t *testing.T) {
+	input := writeFile(t, t.TempDir(), "in.txt", "it (cap) ,zz (hex)\n")
+	proc := processor.New(processor.WithTrace(true))
+
+	var ok bool
+	out := captureStdout(t, func() { ok = showTraces(proc, []string{input}) })
+	want := "=== " + input + "\n" +
+		input + ":1:4: (cap) \"it\" -> \"It\"\n" +
+		input + ":1:14: (hex) changed nothing\n" +
+		"--- after markers\nIt ,zz \n" +
+		"--- after spaces\nIt ,zz\n" +
+		"--- after punctuation\nIt, zz\n"
+	if !ok || out != want {
+		t.Errorf("got %v and\n%s\nwant true and\n%s", ok, out, want)
+	}
+}